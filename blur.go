@@ -0,0 +1,234 @@
+package Netpbm2
+
+import "math"
+
+// boxBlurSigma is the sigma above which GaussianBlur is approximated by three
+// box blurs, whose cost does not depend on the radius.
+const boxBlurSigma = 8.0
+
+// gaussianKernel returns the normalized one-dimensional Gaussian of sigma,
+// cut at 3 sigma.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		x := float64(i - radius)
+		k[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// samples returns the samples of r as floats, without padding between rows.
+func (r raster) samples() []float64 {
+	values := make([]float64, 0, r.width*r.height*r.channels)
+	for y := 0; y < r.height; y++ {
+		for _, v := range r.pix[y*r.stride : y*r.stride+r.width*r.channels] {
+			values = append(values, float64(v))
+		}
+	}
+	return values
+}
+
+// fromSamples returns a raster shaped like r holding values, rounded and
+// clamped between 0 and the max value.
+func (r raster) fromSamples(values []float64) raster {
+	dst := newRaster(r.width, r.height, r.channels, r.max)
+	for i, v := range values {
+		dst.pix[i] = toSample(v, r.max)
+	}
+	return dst
+}
+
+// boxLine replaces each of the n pixels of a line with the mean of the 2*radius+1
+// pixels around it, the edge pixels being repeated. Sample c of pixel i is
+// values[i*step+c]. It keeps a running sum, so its cost does not depend on
+// radius.
+func boxLine(values, line []float64, n, step, channels, radius int) {
+	size := float64(2*radius + 1)
+	for c := 0; c < channels; c++ {
+		for i := 0; i < n; i++ {
+			line[i] = values[i*step+c]
+		}
+		var sum float64
+		for j := -radius; j <= radius; j++ {
+			sum += line[clamp(j, 0, n-1)]
+		}
+		for i := 0; i < n; i++ {
+			values[i*step+c] = sum / size
+			sum += line[clamp(i+radius+1, 0, n-1)] - line[clamp(i-radius, 0, n-1)]
+		}
+	}
+}
+
+// boxBlur blurs values, laid out like a width*height raster of channels
+// samples, with a box of radius pixels in each direction.
+func boxBlur(values []float64, width, height, channels, radius int) {
+	if radius <= 0 || width == 0 || height == 0 {
+		return
+	}
+	line := make([]float64, max(width, height))
+	for y := 0; y < height; y++ {
+		boxLine(values[y*width*channels:], line, width, channels, channels, radius)
+	}
+	for x := 0; x < width; x++ {
+		boxLine(values[x*channels:], line, height, width*channels, channels, radius)
+	}
+}
+
+// gaussianBoxes returns the radii of the three box blurs whose succession is
+// closest to a Gaussian of sigma, as computed by Kovesi.
+func gaussianBoxes(sigma float64) [3]int {
+	const n = 3
+	ideal := math.Sqrt(12*sigma*sigma/n + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+	m := int(math.Round((12*sigma*sigma - n*float64(lower*lower) - 4*n*float64(lower) - 3*n) / (-4*float64(lower) - 4)))
+	var radii [3]int
+	for i := range radii {
+		size := upper
+		if i < m {
+			size = lower
+		}
+		radii[i] = (size - 1) / 2
+	}
+	return radii
+}
+
+// gaussianBlur returns the samples of r blurred by a Gaussian of sigma, the
+// edge pixels being repeated.
+func gaussianBlur(r raster, sigma float64) []float64 {
+	if sigma > boxBlurSigma {
+		values := r.samples()
+		for _, radius := range gaussianBoxes(sigma) {
+			boxBlur(values, r.width, r.height, r.channels, radius)
+		}
+		return values
+	}
+	k := gaussianKernel(sigma)
+	kernel, _ := NewSeparableKernel(k, k)
+	return convolve(r, kernel, EdgeClamp)
+}
+
+// motionKernel returns the kernel averaging the pixels along a line of length
+// pixels centered on the pixel, going angle degrees counterclockwise from the
+// horizontal.
+func motionKernel(length int, angle float64) Kernel {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	radius := (length + 1) / 2
+	size := 2*radius + 1
+	values := make([]float64, size*size)
+	// Add the cells under evenly spaced points of the line, each point
+	// standing for the same length of it
+	steps := 8 * length
+	for i := 0; i < steps; i++ {
+		t := (float64(i)+0.5)*float64(length)/float64(steps) - float64(length)/2
+		x := radius + int(math.Round(t*cos))
+		y := radius - int(math.Round(t*sin))
+		values[y*size+x]++
+	}
+	k, _ := NewKernel(size, size, values)
+	return k
+}
+
+// unsharpMask returns the samples of r sharpened by adding amount times their
+// difference with r blurred by a Gaussian of radius, where that difference is
+// at least threshold.
+func unsharpMask(r raster, radius, amount float64, threshold uint16) []float64 {
+	values := r.samples()
+	blurred := gaussianBlur(r, radius)
+	for i, v := range values {
+		diff := v - blurred[i]
+		if math.Abs(diff) >= float64(threshold) {
+			values[i] = v + amount*diff
+		}
+	}
+	return values
+}
+
+// GaussianBlur blurs the PGM image with a Gaussian of standard deviation
+// sigma, in pixels. Above a sigma of 8 it is approximated by three box blurs,
+// which are much faster. The edge pixels are repeated beyond the edges.
+func (pgm *PGM) GaussianBlur(sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	r := pgm.raster()
+	pgm.setRaster(r.fromSamples(gaussianBlur(r, sigma)))
+}
+
+// BoxBlur replaces each pixel of the PGM image with the mean of the square of
+// 2*radius+1 pixels around it. The edge pixels are repeated beyond the edges.
+func (pgm *PGM) BoxBlur(radius int) {
+	r := pgm.raster()
+	values := r.samples()
+	boxBlur(values, r.width, r.height, r.channels, radius)
+	pgm.setRaster(r.fromSamples(values))
+}
+
+// MotionBlur blurs the PGM image along a line of length pixels going angle
+// degrees counterclockwise from the horizontal, as if it moved while exposed.
+func (pgm *PGM) MotionBlur(length int, angle float64) {
+	if length <= 1 {
+		return
+	}
+	pgm.Convolve(motionKernel(length, angle), &ConvolveOptions{Normalize: true})
+}
+
+// UnsharpMask sharpens the PGM image by adding amount times its difference
+// with the image blurred by a Gaussian of sigma radius. Differences below
+// threshold, in sample values, are left alone so that noise is not sharpened.
+func (pgm *PGM) UnsharpMask(radius, amount float64, threshold uint16) {
+	if radius <= 0 {
+		return
+	}
+	r := pgm.raster()
+	pgm.setRaster(r.fromSamples(unsharpMask(r, radius, amount, threshold)))
+}
+
+// GaussianBlur blurs the PPM image with a Gaussian of standard deviation
+// sigma, in pixels. Above a sigma of 8 it is approximated by three box blurs,
+// which are much faster. The edge pixels are repeated beyond the edges.
+func (ppm *PPM) GaussianBlur(sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	r := ppm.raster()
+	ppm.setRaster(r.fromSamples(gaussianBlur(r, sigma)))
+}
+
+// BoxBlur replaces each pixel of the PPM image with the mean of the square of
+// 2*radius+1 pixels around it. The edge pixels are repeated beyond the edges.
+func (ppm *PPM) BoxBlur(radius int) {
+	r := ppm.raster()
+	values := r.samples()
+	boxBlur(values, r.width, r.height, r.channels, radius)
+	ppm.setRaster(r.fromSamples(values))
+}
+
+// MotionBlur blurs the PPM image along a line of length pixels going angle
+// degrees counterclockwise from the horizontal, as if it moved while exposed.
+func (ppm *PPM) MotionBlur(length int, angle float64) {
+	if length <= 1 {
+		return
+	}
+	ppm.Convolve(motionKernel(length, angle), &ConvolveOptions{Normalize: true})
+}
+
+// UnsharpMask sharpens the PPM image by adding amount times its difference
+// with the image blurred by a Gaussian of sigma radius. Differences below
+// threshold, in sample values, are left alone so that noise is not sharpened.
+func (ppm *PPM) UnsharpMask(radius, amount float64, threshold uint16) {
+	if radius <= 0 {
+		return
+	}
+	r := ppm.raster()
+	ppm.setRaster(r.fromSamples(unsharpMask(r, radius, amount, threshold)))
+}
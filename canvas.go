@@ -0,0 +1,298 @@
+package Netpbm2
+
+import "image"
+
+// EdgeMode chooses what lies beyond the edges of an image when it is extended.
+type EdgeMode int
+
+const (
+	// EdgeClamp repeats the edge pixels, so abc becomes aaa|abc|ccc. It is the
+	// zero value.
+	EdgeClamp EdgeMode = iota
+	// EdgeMirror reflects the image at its edges, the edge pixels being
+	// repeated, so abc becomes cba|abc|cba.
+	EdgeMirror
+	// EdgeWrap tiles the image, so abc becomes abc|abc|abc.
+	EdgeWrap
+	// EdgeZero puts zeros beyond the edges, so abc becomes 000|abc|000. In a
+	// PBM image 0 is white.
+	EdgeZero
+)
+
+// index returns the pixel of a row or column of n pixels that stands for the
+// pixel i, which may be beyond its edges, or -1 for a zero.
+func (mode EdgeMode) index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch mode {
+	case EdgeZero:
+		return -1
+	case EdgeMirror:
+		// The image and its reflection make a period of 2n pixels
+		i %= 2 * n
+		if i < 0 {
+			i += 2 * n
+		}
+		if i >= n {
+			i = 2*n - 1 - i
+		}
+		return i
+	case EdgeWrap:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		return i
+	}
+	return clamp(i, 0, n-1)
+}
+
+// source returns, for each pixel of a new image, the pixel of the old one it
+// is copied from, and false when it is filled instead.
+type source func(x, y int) (int, int, bool)
+
+// remap returns a raster of width*height pixels copied from r as told by src,
+// the others being set to fill.
+func (r raster) remap(width, height int, src source, fill []uint16) raster {
+	dst := newRaster(width, height, r.channels, r.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			out := dst.pix[y*dst.stride+x*dst.channels : y*dst.stride+(x+1)*dst.channels]
+			if sx, sy, ok := src(x, y); ok {
+				i := sy*r.stride + sx*r.channels
+				copy(out, r.pix[i:i+r.channels])
+			} else {
+				copy(out, fill)
+			}
+		}
+	}
+	return dst
+}
+
+// remap replaces the PBM image with one of width*height pixels copied from it
+// as told by src, the others being set to fill.
+func (pbm *PBM) remap(width, height int, src source, fill bool) {
+	dst := NewPBM(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if sx, sy, ok := src(x, y); ok {
+				dst.SetBit(x, y, pbm.BitAt(sx, sy))
+			} else {
+				dst.SetBit(x, y, fill)
+			}
+		}
+	}
+	dst.magicNumber = pbm.magicNumber
+	*pbm = *dst
+}
+
+// cropSource returns the source of the pixels of r, which must be inside the image.
+func cropSource(r image.Rectangle) source {
+	return func(x, y int) (int, int, bool) {
+		return r.Min.X + x, r.Min.Y + y, true
+	}
+}
+
+// padSource returns the source of the pixels of a width*height image padded
+// with top rows above it and left columns on its left.
+func padSource(width, height, top, left int) source {
+	return func(x, y int) (int, int, bool) {
+		x, y = x-left, y-top
+		return x, y, x >= 0 && x < width && y >= 0 && y < height
+	}
+}
+
+// extendSource is like padSource but takes the new pixels from the image as
+// told by mode, those that are zeros being filled.
+func extendSource(width, height, top, left int, mode EdgeMode) source {
+	return func(x, y int) (int, int, bool) {
+		x, y = mode.index(x-left, width), mode.index(y-top, height)
+		return x, y, x >= 0 && y >= 0
+	}
+}
+
+// padSize returns the size of a width*height image padded by top, right,
+// bottom and left pixels, negative values counting as 0.
+func padSize(width, height int, top, right, bottom, left *int) (int, int) {
+	for _, v := range []*int{top, right, bottom, left} {
+		*v = max(*v, 0)
+	}
+	return width + *left + *right, height + *top + *bottom
+}
+
+// trimRect returns the smallest rectangle of a width*height image holding
+// every pixel that is not background, or the whole image when all of them
+// are background.
+func trimRect(width, height int, isBackground func(x, y int) bool) image.Rectangle {
+	bounds := image.Rect(0, 0, width, height)
+	found := image.Rectangle{}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !isBackground(x, y) {
+				found = found.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if found.Empty() {
+		return bounds
+	}
+	return found
+}
+
+// cornerVote returns the index of the corner whose color is shared by the
+// most corners, same telling whether two corners have the same color. Ties go
+// to the first corner, in the order top-left, top-right, bottom-left and
+// bottom-right.
+func cornerVote(same func(i, j int) bool) int {
+	best, votes := 0, 0
+	for i := 0; i < 4; i++ {
+		n := 0
+		for j := 0; j < 4; j++ {
+			if same(i, j) {
+				n++
+			}
+		}
+		if n > votes {
+			best, votes = i, n
+		}
+	}
+	return best
+}
+
+// corners returns the coordinates of the 4 corners of a width*height image.
+func corners(width, height int) [4]image.Point {
+	return [4]image.Point{{0, 0}, {width - 1, 0}, {0, height - 1}, {width - 1, height - 1}}
+}
+
+// Crop keeps the part of the PBM image inside r, clipped to its bounds. The
+// pixels are copied, use SubImage to share them.
+func (pbm *PBM) Crop(r image.Rectangle) {
+	r = r.Intersect(pbm.Bounds())
+	pbm.remap(r.Dx(), r.Dy(), cropSource(r), false)
+}
+
+// Pad adds top, right, bottom and left rows and columns of fill around the PBM
+// image, true being black.
+func (pbm *PBM) Pad(top, right, bottom, left int, fill bool) {
+	width, height := padSize(pbm.width, pbm.height, &top, &right, &bottom, &left)
+	pbm.remap(width, height, padSource(pbm.width, pbm.height, top, left), fill)
+}
+
+// Extend adds top, right, bottom and left rows and columns around the PBM
+// image, taken from the image as told by mode.
+func (pbm *PBM) Extend(top, right, bottom, left int, mode EdgeMode) {
+	if pbm.width == 0 || pbm.height == 0 {
+		return
+	}
+	width, height := padSize(pbm.width, pbm.height, &top, &right, &bottom, &left)
+	pbm.remap(width, height, extendSource(pbm.width, pbm.height, top, left, mode), false)
+}
+
+// Trim crops the rows and columns of background color from the edges of the
+// PBM image, like pnmcrop, and returns the rectangle it kept. The background
+// is the color of most of the corners. An image made only of background is
+// left as it is.
+func (pbm *PBM) Trim() image.Rectangle {
+	if pbm.width == 0 || pbm.height == 0 {
+		return pbm.Bounds()
+	}
+	c := corners(pbm.width, pbm.height)
+	corner := c[cornerVote(func(i, j int) bool {
+		return pbm.BitAt(c[i].X, c[i].Y) == pbm.BitAt(c[j].X, c[j].Y)
+	})]
+	background := pbm.BitAt(corner.X, corner.Y)
+	r := trimRect(pbm.width, pbm.height, func(x, y int) bool {
+		return pbm.BitAt(x, y) == background
+	})
+	pbm.Crop(r)
+	return r
+}
+
+// Crop keeps the part of the PGM image inside r, clipped to its bounds. The
+// pixels are copied, use SubImage to share them.
+func (pgm *PGM) Crop(r image.Rectangle) {
+	r = r.Intersect(pgm.Bounds())
+	pgm.setRaster(pgm.raster().remap(r.Dx(), r.Dy(), cropSource(r), nil))
+}
+
+// Pad adds top, right, bottom and left rows and columns of fill around the PGM
+// image.
+func (pgm *PGM) Pad(top, right, bottom, left int, fill uint16) {
+	width, height := padSize(pgm.width, pgm.height, &top, &right, &bottom, &left)
+	pgm.setRaster(pgm.raster().remap(width, height, padSource(pgm.width, pgm.height, top, left), []uint16{fill}))
+}
+
+// Extend adds top, right, bottom and left rows and columns around the PGM
+// image, taken from the image as told by mode.
+func (pgm *PGM) Extend(top, right, bottom, left int, mode EdgeMode) {
+	if pgm.width == 0 || pgm.height == 0 {
+		return
+	}
+	width, height := padSize(pgm.width, pgm.height, &top, &right, &bottom, &left)
+	pgm.setRaster(pgm.raster().remap(width, height, extendSource(pgm.width, pgm.height, top, left, mode), nil))
+}
+
+// Trim crops the rows and columns of background color from the edges of the
+// PGM image, like pnmcrop, and returns the rectangle it kept. The background
+// is the color of most of the corners. An image made only of background is
+// left as it is.
+func (pgm *PGM) Trim() image.Rectangle {
+	if pgm.width == 0 || pgm.height == 0 {
+		return pgm.Bounds()
+	}
+	c := corners(pgm.width, pgm.height)
+	corner := c[cornerVote(func(i, j int) bool {
+		return pgm.GrayAt(c[i].X, c[i].Y) == pgm.GrayAt(c[j].X, c[j].Y)
+	})]
+	background := pgm.GrayAt(corner.X, corner.Y)
+	r := trimRect(pgm.width, pgm.height, func(x, y int) bool {
+		return pgm.GrayAt(x, y) == background
+	})
+	pgm.Crop(r)
+	return r
+}
+
+// Crop keeps the part of the PPM image inside r, clipped to its bounds. The
+// pixels are copied, use SubImage to share them.
+func (ppm *PPM) Crop(r image.Rectangle) {
+	r = r.Intersect(ppm.Bounds())
+	ppm.setRaster(ppm.raster().remap(r.Dx(), r.Dy(), cropSource(r), nil))
+}
+
+// Pad adds top, right, bottom and left rows and columns of fill around the PPM
+// image.
+func (ppm *PPM) Pad(top, right, bottom, left int, fill Pixel) {
+	width, height := padSize(ppm.width, ppm.height, &top, &right, &bottom, &left)
+	ppm.setRaster(ppm.raster().remap(width, height, padSource(ppm.width, ppm.height, top, left), []uint16{fill.R, fill.G, fill.B}))
+}
+
+// Extend adds top, right, bottom and left rows and columns around the PPM
+// image, taken from the image as told by mode.
+func (ppm *PPM) Extend(top, right, bottom, left int, mode EdgeMode) {
+	if ppm.width == 0 || ppm.height == 0 {
+		return
+	}
+	width, height := padSize(ppm.width, ppm.height, &top, &right, &bottom, &left)
+	ppm.setRaster(ppm.raster().remap(width, height, extendSource(ppm.width, ppm.height, top, left, mode), nil))
+}
+
+// Trim crops the rows and columns of background color from the edges of the
+// PPM image, like pnmcrop, and returns the rectangle it kept. The background
+// is the color of most of the corners. An image made only of background is
+// left as it is.
+func (ppm *PPM) Trim() image.Rectangle {
+	if ppm.width == 0 || ppm.height == 0 {
+		return ppm.Bounds()
+	}
+	c := corners(ppm.width, ppm.height)
+	corner := c[cornerVote(func(i, j int) bool {
+		return ppm.PixelAt(c[i].X, c[i].Y) == ppm.PixelAt(c[j].X, c[j].Y)
+	})]
+	background := ppm.PixelAt(corner.X, corner.Y)
+	r := trimRect(ppm.width, ppm.height, func(x, y int) bool {
+		return ppm.PixelAt(x, y) == background
+	})
+	ppm.Crop(r)
+	return r
+}
@@ -0,0 +1,232 @@
+package Netpbm2
+
+import (
+	"fmt"
+	"math"
+)
+
+// Kernel is a convolution kernel of odd width and height, whose center is
+// applied to the pixel being computed.
+type Kernel struct {
+	width, height int
+	// values holds the rows of the kernel one after the other
+	values []float64
+	// row and column are set when the kernel is their outer product, so that
+	// it can be applied as two one-dimensional passes
+	row, column []float64
+}
+
+// NewKernel returns a kernel of width*height values given row by row. width
+// and height must be odd. A kernel that is the product of a row and a column,
+// such as a box or a Gaussian, is detected and applied in two passes.
+func NewKernel(width, height int, values []float64) (Kernel, error) {
+	if width <= 0 || height <= 0 || width%2 == 0 || height%2 == 0 {
+		return Kernel{}, fmt.Errorf("kernel size %dx%d is not odd", width, height)
+	}
+	if len(values) != width*height {
+		return Kernel{}, fmt.Errorf("kernel of %dx%d has %d values", width, height, len(values))
+	}
+	k := Kernel{width: width, height: height, values: append([]float64(nil), values...)}
+	k.row, k.column = k.factor()
+	return k, nil
+}
+
+// NewSeparableKernel returns the kernel whose value at (x, y) is
+// row[x]*column[y], which is applied in two passes. The lengths of row and
+// column must be odd.
+func NewSeparableKernel(row, column []float64) (Kernel, error) {
+	if len(row)%2 == 0 || len(column)%2 == 0 {
+		return Kernel{}, fmt.Errorf("kernel size %dx%d is not odd", len(row), len(column))
+	}
+	k := Kernel{width: len(row), height: len(column), values: make([]float64, len(row)*len(column))}
+	for y, c := range column {
+		for x, r := range row {
+			k.values[y*k.width+x] = r * c
+		}
+	}
+	k.row = append([]float64(nil), row...)
+	k.column = append([]float64(nil), column...)
+	return k, nil
+}
+
+// Size returns the width and height of the kernel.
+func (k Kernel) Size() (int, int) {
+	return k.width, k.height
+}
+
+// At returns the value of the kernel at (x, y), (0, 0) being its top-left corner.
+func (k Kernel) At(x, y int) float64 {
+	return k.values[y*k.width+x]
+}
+
+// Sum returns the sum of the values of the kernel.
+func (k Kernel) Sum() float64 {
+	var sum float64
+	for _, v := range k.values {
+		sum += v
+	}
+	return sum
+}
+
+// scale returns the kernel with all its values multiplied by f.
+func (k Kernel) scale(f float64) Kernel {
+	scaled := Kernel{width: k.width, height: k.height, values: make([]float64, len(k.values))}
+	for i, v := range k.values {
+		scaled.values[i] = v * f
+	}
+	if k.row != nil {
+		scaled.row = make([]float64, len(k.row))
+		for i, v := range k.row {
+			scaled.row[i] = v * f
+		}
+		scaled.column = k.column
+	}
+	return scaled
+}
+
+// factor returns a row and a column whose outer product is the kernel, or nil
+// when there are none.
+func (k Kernel) factor() ([]float64, []float64) {
+	// Take the row and the column of the largest value, any other row must be
+	// a multiple of that row
+	pivot := 0
+	for i, v := range k.values {
+		if math.Abs(v) > math.Abs(k.values[pivot]) {
+			pivot = i
+		}
+	}
+	p := k.values[pivot]
+	if p == 0 {
+		return nil, nil
+	}
+	px, py := pivot%k.width, pivot/k.width
+	row := append([]float64(nil), k.values[py*k.width:(py+1)*k.width]...)
+	column := make([]float64, k.height)
+	for y := range column {
+		column[y] = k.values[y*k.width+px] / p
+	}
+	for y, c := range column {
+		for x, r := range row {
+			if math.Abs(r*c-k.values[y*k.width+x]) > 1e-9*math.Abs(p) {
+				return nil, nil
+			}
+		}
+	}
+	return row, column
+}
+
+// ConvolveOptions controls how a kernel is applied. A nil *ConvolveOptions is
+// the same as the zero value.
+type ConvolveOptions struct {
+	// Normalize divides the kernel by the sum of its values, unless it is 0,
+	// so that it keeps the brightness of the image.
+	Normalize bool
+	// Bias is added to each result, in sample values, such as half the max
+	// value to show the negative results of an emboss kernel.
+	Bias float64
+	// Edge chooses what the kernel sees beyond the edges of the image.
+	Edge EdgeMode
+}
+
+// convolve returns the samples of r convolved with k, unrounded and unclamped,
+// laid out like r.pix without padding between rows.
+func convolve(r raster, k Kernel, edge EdgeMode) []float64 {
+	out := make([]float64, r.width*r.height*r.channels)
+	if len(out) == 0 {
+		return out
+	}
+	if k.row != nil {
+		// First the rows of the image with the row of the kernel, then the
+		// columns of that result with the column of the kernel
+		tmp := make([]float64, len(out))
+		line := r.width * r.channels
+		for y := 0; y < r.height; y++ {
+			convolveLine(tmp[y*line:], r.width, r.channels, r.channels, k.row, edge, func(x, c int) float64 {
+				return float64(r.pix[y*r.stride+x*r.channels+c])
+			})
+		}
+		for x := 0; x < r.width; x++ {
+			convolveLine(out[x*r.channels:], r.height, line, r.channels, k.column, edge, func(y, c int) float64 {
+				return tmp[y*line+x*r.channels+c]
+			})
+		}
+		return out
+	}
+	cx, cy := k.width/2, k.height/2
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			for j := 0; j < k.height; j++ {
+				sy := edge.index(y+j-cy, r.height)
+				if sy < 0 {
+					continue
+				}
+				for i := 0; i < k.width; i++ {
+					sx := edge.index(x+i-cx, r.width)
+					w := k.values[j*k.width+i]
+					if sx < 0 || w == 0 {
+						continue
+					}
+					in := r.pix[sy*r.stride+sx*r.channels:]
+					o := out[(y*r.width+x)*r.channels:]
+					for c := 0; c < r.channels; c++ {
+						o[c] += w * float64(in[c])
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// convolveLine convolves a line of n pixels of channels samples with the
+// one-dimensional kernel k, reading sample c of pixel i with at and adding the
+// samples of pixel i to out[i*step:].
+func convolveLine(out []float64, n, step, channels int, k []float64, edge EdgeMode, at func(i, c int) float64) {
+	center := len(k) / 2
+	for i := 0; i < n; i++ {
+		o := out[i*step:]
+		for j, w := range k {
+			s := edge.index(i+j-center, n)
+			if s < 0 || w == 0 {
+				continue
+			}
+			for c := 0; c < channels; c++ {
+				o[c] += w * at(s, c)
+			}
+		}
+	}
+}
+
+// apply convolves r with k as told by opts and returns the result as a new
+// raster.
+func (opts *ConvolveOptions) apply(r raster, k Kernel) raster {
+	var o ConvolveOptions
+	if opts != nil {
+		o = *opts
+	}
+	if sum := k.Sum(); o.Normalize && sum != 0 {
+		k = k.scale(1 / sum)
+	}
+	values := convolve(r, k, o.Edge)
+	dst := newRaster(r.width, r.height, r.channels, r.max)
+	for i, v := range values {
+		dst.pix[i] = toSample(v+o.Bias, r.max)
+	}
+	return dst
+}
+
+// Convolve applies k to the PGM image: each pixel becomes the sum of its
+// neighbors weighted by the values of k, centered on it. The kernel is not
+// flipped, like with pnmconvol. The results are rounded and clamped between 0
+// and the max value.
+func (pgm *PGM) Convolve(k Kernel, opts *ConvolveOptions) {
+	pgm.setRaster(opts.apply(pgm.raster(), k))
+}
+
+// Convolve applies k to each channel of the PPM image: each sample becomes the
+// sum of the same sample of its neighbors weighted by the values of k,
+// centered on it. The kernel is not flipped, like with pnmconvol. The results
+// are rounded and clamped between 0 and the max value.
+func (ppm *PPM) Convolve(k Kernel, opts *ConvolveOptions) {
+	ppm.setRaster(opts.apply(ppm.raster(), k))
+}
@@ -0,0 +1,206 @@
+package Netpbm2
+
+import "math"
+
+// GradientOperator chooses the kernels used to measure the gradient of an image.
+type GradientOperator int
+
+const (
+	// OperatorSobel smooths across the derivative with 1 2 1. It is the zero value.
+	OperatorSobel GradientOperator = iota
+	// OperatorPrewitt smooths across the derivative with 1 1 1.
+	OperatorPrewitt
+	// OperatorScharr smooths across the derivative with 3 10 3, which gives
+	// the most accurate directions.
+	OperatorScharr
+)
+
+// smoothing returns the kernel the operator smooths across the derivative with.
+func (op GradientOperator) smoothing() []float64 {
+	switch op {
+	case OperatorPrewitt:
+		return []float64{1, 1, 1}
+	case OperatorScharr:
+		return []float64{3, 10, 3}
+	}
+	return []float64{1, 2, 1}
+}
+
+// gradients returns the horizontal and vertical derivatives of the gray
+// raster r measured with op, scaled so that a step from 0 to the max value
+// gives the max value. The vertical derivative is positive when the image
+// gets lighter upwards.
+func gradients(r raster, op GradientOperator) ([]float64, []float64) {
+	smooth := op.smoothing()
+	var sum float64
+	for _, v := range smooth {
+		sum += v
+	}
+	derivative := []float64{-1 / sum, 0, 1 / sum}
+	up := []float64{1 / sum, 0, -1 / sum}
+	kx, _ := NewSeparableKernel(derivative, smooth)
+	ky, _ := NewSeparableKernel(smooth, up)
+	return convolve(r, kx, EdgeClamp), convolve(r, ky, EdgeClamp)
+}
+
+// Gradient returns the gradient magnitude and direction of the PGM image
+// measured with op, as two PGM images with its max value. The magnitude is
+// the max value for a step from 0 to the max value, and is clamped beyond.
+// The direction goes counterclockwise from 0, the image getting lighter to the
+// right, to the max value for a full turn.
+func (pgm *PGM) Gradient(op GradientOperator) (*PGM, *PGM) {
+	gx, gy := gradients(pgm.raster(), op)
+	magnitude := NewPGM(pgm.width, pgm.height, pgm.max)
+	direction := NewPGM(pgm.width, pgm.height, pgm.max)
+	for i := range gx {
+		magnitude.pix[i] = toSample(math.Hypot(gx[i], gy[i]), pgm.max)
+		angle := math.Atan2(gy[i], gx[i])
+		if angle < 0 {
+			angle += 2 * math.Pi
+		}
+		direction.pix[i] = toSample(angle/(2*math.Pi)*float64(pgm.max), pgm.max)
+	}
+	return magnitude, direction
+}
+
+// Gradient returns the gradient magnitude and direction of the PPM image
+// converted by ToPGM. See PGM.Gradient.
+func (ppm *PPM) Gradient(op GradientOperator) (*PGM, *PGM) {
+	return ppm.ToPGM().Gradient(op)
+}
+
+// logKernel returns the Laplacian of a Gaussian of sigma, multiplied by sigma²
+// so that its response does not depend on the scale, and shifted so that its
+// values add up to 0. A sigma of 0 gives the 3x3 Laplacian.
+func logKernel(sigma float64) Kernel {
+	if sigma <= 0 {
+		k, _ := NewKernel(3, 3, []float64{0, 1, 0, 1, -4, 1, 0, 1, 0})
+		return k
+	}
+	radius := int(math.Ceil(3 * sigma))
+	size := 2*radius + 1
+	values := make([]float64, size*size)
+	var sum float64
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			r2 := float64(x*x+y*y) / (2 * sigma * sigma)
+			v := -(1 - r2) * math.Exp(-r2) / (math.Pi * sigma * sigma)
+			values[(y+radius)*size+x+radius] = v
+			sum += v
+		}
+	}
+	for i := range values {
+		values[i] -= sum / float64(len(values))
+	}
+	k, _ := NewKernel(size, size, values)
+	return k
+}
+
+// LaplacianOfGaussian returns the Laplacian of the PGM image smoothed by a
+// Gaussian of sigma, as a PGM image with its max value where 0 is half the max
+// value. Edges are where it crosses half the max value. A sigma of 0 gives the
+// plain 3x3 Laplacian.
+func (pgm *PGM) LaplacianOfGaussian(sigma float64) *PGM {
+	log := pgm.clone()
+	log.Convolve(logKernel(sigma), &ConvolveOptions{Bias: float64(pgm.max) / 2})
+	return log
+}
+
+// LaplacianOfGaussian returns the Laplacian of Gaussian of the PPM image
+// converted by ToPGM. See PGM.LaplacianOfGaussian.
+func (ppm *PPM) LaplacianOfGaussian(sigma float64) *PGM {
+	return ppm.ToPGM().LaplacianOfGaussian(sigma)
+}
+
+// Canny returns the edges of the PGM image found by the Canny detector as a
+// PBM image where edges are black. The image is smoothed by a Gaussian of
+// sigma, its Sobel gradient is thinned to its local maxima, then the pixels
+// whose magnitude is above high are kept along with those above low that are
+// connected to them. low and high are fractions of the max value, such as 0.1
+// and 0.3.
+func (pgm *PGM) Canny(sigma, low, high float64) *PBM {
+	width, height := pgm.width, pgm.height
+	edges := NewPBM(width, height)
+	if width == 0 || height == 0 {
+		return edges
+	}
+	r := pgm.raster()
+	if sigma > 0 {
+		r = r.fromSamples(gaussianBlur(r, sigma))
+	}
+	gx, gy := gradients(r, OperatorSobel)
+	magnitude := make([]float64, len(gx))
+	for i := range gx {
+		magnitude[i] = math.Hypot(gx[i], gy[i]) / float64(pgm.max)
+	}
+	at := func(x, y int) float64 {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return 0
+		}
+		return magnitude[y*width+x]
+	}
+
+	// Keep the pixels that are a maximum across the edge, the direction of
+	// the gradient being rounded to a multiple of 45°
+	const (
+		none = iota
+		weak
+		strong
+	)
+	class := make([]byte, len(magnitude))
+	var stack []int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			m := magnitude[i]
+			if m < low || m == 0 {
+				continue
+			}
+			angle := math.Atan2(gy[i], gx[i]) * 180 / math.Pi
+			if angle < 0 {
+				angle += 180
+			}
+			// The rows go down while gy goes up
+			dx, dy := 1, 0
+			switch {
+			case angle >= 22.5 && angle < 67.5:
+				dx, dy = 1, -1
+			case angle >= 67.5 && angle < 112.5:
+				dx, dy = 0, 1
+			case angle >= 112.5 && angle < 157.5:
+				dx, dy = 1, 1
+			}
+			if m < at(x+dx, y+dy) || m <= at(x-dx, y-dy) {
+				continue
+			}
+			if m >= high {
+				class[i] = strong
+				stack = append(stack, i)
+			} else {
+				class[i] = weak
+			}
+		}
+	}
+
+	// Follow the weak pixels connected to the strong ones
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%width, i/width
+		edges.SetBit(x, y, true)
+		for ny := max(y-1, 0); ny <= min(y+1, height-1); ny++ {
+			for nx := max(x-1, 0); nx <= min(x+1, width-1); nx++ {
+				if j := ny*width + nx; class[j] == weak {
+					class[j] = strong
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// Canny returns the edges of the PPM image converted by ToPGM. See PGM.Canny.
+func (ppm *PPM) Canny(sigma, low, high float64) *PBM {
+	return ppm.ToPGM().Canny(sigma, low, high)
+}
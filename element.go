@@ -0,0 +1,102 @@
+package Netpbm2
+
+import "image"
+
+// StructuringElement is the shape a morphological operation probes an image
+// with: a set of pixels around an origin, which is placed on each pixel of
+// the image in turn.
+type StructuringElement struct {
+	// offsets are the pixels of the element, relative to its origin
+	offsets []image.Point
+	// rect is set for a rectangle, which is applied as a horizontal line
+	// followed by a vertical one
+	rect bool
+	// bounds is the smallest rectangle holding the offsets
+	bounds image.Rectangle
+}
+
+// newElement returns the element made of offsets.
+func newElement(offsets []image.Point) StructuringElement {
+	se := StructuringElement{offsets: offsets}
+	for _, p := range offsets {
+		se.bounds = se.bounds.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+	}
+	return se
+}
+
+// NewRectangleElement returns a filled rectangle of width*height pixels whose
+// origin is its center, or the pixel right below and right of it when a side
+// is even.
+func NewRectangleElement(width, height int) StructuringElement {
+	var offsets []image.Point
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offsets = append(offsets, image.Pt(x-width/2, y-height/2))
+		}
+	}
+	se := newElement(offsets)
+	se.rect = len(offsets) > 0
+	return se
+}
+
+// NewCrossElement returns a cross whose arms are radius pixels long on each
+// side of its origin, at its center.
+func NewCrossElement(radius int) StructuringElement {
+	offsets := []image.Point{{0, 0}}
+	for i := 1; i <= radius; i++ {
+		offsets = append(offsets, image.Pt(-i, 0), image.Pt(i, 0), image.Pt(0, -i), image.Pt(0, i))
+	}
+	return newElement(offsets)
+}
+
+// NewDiskElement returns a disk of the given radius whose origin is its
+// center: the pixels whose distance to the origin is at most radius.
+func NewDiskElement(radius int) StructuringElement {
+	var offsets []image.Point
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= radius*radius {
+				offsets = append(offsets, image.Pt(x, y))
+			}
+		}
+	}
+	return newElement(offsets)
+}
+
+// NewElementFromPBM returns the element made of the black pixels of pbm, whose
+// origin is the pixel origin of pbm.
+func NewElementFromPBM(pbm *PBM, origin image.Point) StructuringElement {
+	var offsets []image.Point
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if pbm.BitAt(x, y) {
+				offsets = append(offsets, image.Pt(x-origin.X, y-origin.Y))
+			}
+		}
+	}
+	return newElement(offsets)
+}
+
+// Bounds returns the smallest rectangle holding the pixels of the element,
+// relative to its origin.
+func (se StructuringElement) Bounds() image.Rectangle {
+	return se.bounds
+}
+
+// Len returns the number of pixels of the element.
+func (se StructuringElement) Len() int {
+	return len(se.offsets)
+}
+
+// lines returns the horizontal and the vertical line whose succession is the
+// rectangle se.
+func (se StructuringElement) lines() (StructuringElement, StructuringElement) {
+	var row, column []image.Point
+	for x := se.bounds.Min.X; x < se.bounds.Max.X; x++ {
+		row = append(row, image.Pt(x, 0))
+	}
+	for y := se.bounds.Min.Y; y < se.bounds.Max.Y; y++ {
+		column = append(column, image.Pt(0, y))
+	}
+	return newElement(row), newElement(column)
+}
@@ -0,0 +1,84 @@
+package Netpbm2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The kinds of problem a FormatError can report. Use errors.Is to check them.
+var (
+	ErrBadMagic         = errors.New("invalid magic number")
+	ErrBadHeader        = errors.New("invalid header")
+	ErrBadSample        = errors.New("invalid sample")
+	ErrSampleOutOfRange = errors.New("sample is above the max value")
+	ErrTruncated        = errors.New("unexpected end of image")
+	ErrLimitExceeded    = errors.New("image is beyond the decode limits")
+)
+
+// FormatError reports a malformed or truncated image. Every reader of the
+// package returns one, wrapping one of the Err values, for anything wrong with
+// the data itself.
+type FormatError struct {
+	// Err is the kind of problem: ErrBadMagic, ErrBadHeader, ErrBadSample,
+	// ErrSampleOutOfRange, ErrTruncated or ErrLimitExceeded.
+	Err error
+	// Offset is the position in the stream of the first byte of Token, or of
+	// the missing data.
+	Offset int64
+	// Token is the faulty token, empty when data is missing.
+	Token string
+	// X and Y are the position of the faulty pixel, -1 when the problem is in
+	// the header.
+	X, Y int
+	// Msg details the problem, Err is used when it is empty.
+	Msg string
+}
+
+func (e *FormatError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = e.Err.Error()
+	}
+	if e.Token != "" {
+		msg += fmt.Sprintf(" %q", e.Token)
+	}
+	msg += fmt.Sprintf(" at offset %d", e.Offset)
+	if e.X >= 0 && e.Y >= 0 {
+		msg += fmt.Sprintf(" (pixel %d, %d)", e.X, e.Y)
+	}
+	return msg
+}
+
+// Unwrap returns Err so that errors.Is works on a FormatError.
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// headerError returns a FormatError for a problem in the header.
+func headerError(err error, offset int64, token, msg string) *FormatError {
+	return &FormatError{Err: err, Offset: offset, Token: token, X: -1, Y: -1, Msg: msg}
+}
+
+// rasterError attaches the position of the pixel being read to err. An end of
+// file becomes an ErrTruncated FormatError.
+func (t *tokenizer) rasterError(err error, x, y int) error {
+	var formatError *FormatError
+	if errors.As(err, &formatError) {
+		formatError.X, formatError.Y = x, y
+		return formatError
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &FormatError{Err: ErrTruncated, Offset: t.offset, X: x, Y: y, Msg: "pixel data is truncated"}
+	}
+	return fmt.Errorf("error reading pixel data at position (%d, %d): %w", x, y, err)
+}
+
+// checkSample returns an ErrSampleOutOfRange FormatError if value is above max.
+func checkSample(value, max int, offset int64, x, y int) error {
+	if value <= max {
+		return nil
+	}
+	return &FormatError{Err: ErrSampleOutOfRange, Offset: offset, Token: fmt.Sprint(value), X: x, Y: y,
+		Msg: fmt.Sprintf("sample is above the max value %d", max)}
+}
@@ -0,0 +1,65 @@
+package Netpbm2
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func(io.Reader) error
+		input  string
+		kind   error
+		offset int64
+		token  string
+		x, y   int
+	}{
+		{"PBM invalid bit", decodePBM, "P1\n3 2\n0 1 0\n1 x 1\n", ErrBadSample, 15, "x", 1, 1},
+		{"PGM invalid sample", decodePGM, "P2\n2 1\n255\n7 abc\n", ErrBadSample, 13, "abc", 1, 0},
+		{"PGM sample above max value", decodePGM, "P2 2 1 15 3 16", ErrSampleOutOfRange, 12, "16", 1, 0},
+		{"PPM truncated", decodePPM, "P6 1 1 255\nAB", ErrTruncated, 13, "", 0, 0},
+		{"P4 row truncated", decodePBM, "P4 10 3\nABC", ErrTruncated, 11, "", 8, 1},
+		{"PAM truncated", decodePAM, "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 2\nMAXVAL 255\nENDHDR\nABC", ErrTruncated, 49, "", 1, 0},
+		{"PAM sample above max value", decodePAM, "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 1\nMAXVAL 15\nENDHDR\n\x01\x10", ErrSampleOutOfRange, 46, "16", 1, 0},
+		{"PAM missing ENDHDR", decodePAM, "P7\nWIDTH 2\nHEIGHT 1\n", ErrTruncated, 20, "", -1, -1},
+		{"PFM missing scale", decodePFM, "PF\n2 2\n", ErrTruncated, 7, "", -1, -1},
+		{"PFM invalid scale", decodePFM, "Pf 1 1 abc\n", ErrBadHeader, 7, "abc", -1, -1},
+		{"PFM truncated", decodePFM, "Pf 2 2 -1\n\x00\x00\x80\x3f\x00\x00\x80\x3f\x00\x00\x80\x3f", ErrTruncated, 22, "", 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.decode(strings.NewReader(test.input))
+			formatError := checkFormatError(t, err, test.kind, test.offset, test.token)
+			if formatError.X != test.x || formatError.Y != test.y {
+				t.Errorf("got pixel (%d, %d), want (%d, %d)", formatError.X, formatError.Y, test.x, test.y)
+			}
+		})
+	}
+}
+
+func decodePBM(r io.Reader) error {
+	_, err := DecodePBM(r)
+	return err
+}
+
+func decodePGM(r io.Reader) error {
+	_, err := DecodePGM(r)
+	return err
+}
+
+func decodePPM(r io.Reader) error {
+	_, err := DecodePPM(r)
+	return err
+}
+
+func decodePAM(r io.Reader) error {
+	_, err := DecodePAM(r)
+	return err
+}
+
+func decodePFM(r io.Reader) error {
+	_, err := DecodePFM(r)
+	return err
+}
@@ -0,0 +1,132 @@
+package Netpbm2
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Register the formats so that image.Decode and image.DecodeConfig recognize
+// them by their magic number, the same way importing image/png does.
+func init() {
+	image.RegisterFormat("pbm", "P1", Decode, DecodeConfig)
+	image.RegisterFormat("pbm", "P4", Decode, DecodeConfig)
+	image.RegisterFormat("pgm", "P2", Decode, DecodeConfig)
+	image.RegisterFormat("pgm", "P5", Decode, DecodeConfig)
+	image.RegisterFormat("ppm", "P3", Decode, DecodeConfig)
+	image.RegisterFormat("ppm", "P6", Decode, DecodeConfig)
+	image.RegisterFormat("pam", "P7", Decode, DecodeConfig)
+}
+
+// Config holds what the header of an image tells about it.
+type Config struct {
+	image.Config
+	MagicNumber string
+	// MaxValue is the max value of a sample, 1 for PBM images.
+	MaxValue int
+	// Depth and TupleType are only set for PAM images.
+	Depth     int
+	TupleType string
+}
+
+// colorModel returns the color model of the image described by h.
+func (h header) colorModel() color.Model {
+	switch h.magicNumber {
+	case "P1", "P4":
+		return BitModel
+	case "P2", "P5":
+		if h.max > 255 {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	}
+	if h.max > 255 {
+		return color.RGBA64Model
+	}
+	return color.RGBAModel
+}
+
+// DecodeHeader reads the header of a PBM, PGM, PPM or PAM image from r without
+// reading or allocating the pixels.
+func DecodeHeader(r io.Reader) (Config, error) {
+	t := newTokenizer(r)
+	if magicNumber, _ := t.peek(2); string(magicNumber) == "P7" {
+		pam, err := readPAMHeader(t)
+		if err != nil {
+			return Config{}, err
+		}
+		return Config{
+			Config:      image.Config{ColorModel: pam.ColorModel(), Width: pam.width, Height: pam.height},
+			MagicNumber: "P7",
+			MaxValue:    int(pam.max),
+			Depth:       pam.depth,
+			TupleType:   pam.tupleType,
+		}, nil
+	}
+	h, err := t.header()
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Config:      image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height},
+		MagicNumber: h.magicNumber,
+		MaxValue:    h.max,
+	}, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a PBM, PGM, PPM or
+// PAM image without reading the pixels.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	config, err := DecodeHeader(r)
+	return config.Config, err
+}
+
+// Decode reads a PBM, PGM, PPM or PAM image from r, depending on its magic number.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, DefaultDecodeOptions())
+}
+
+// DecodeWithOptions is like Decode but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodeWithOptions(r io.Reader, opts *DecodeOptions) (image.Image, error) {
+	t := newTokenizer(r)
+	magicNumber, err := t.peek(2)
+	if err == io.EOF {
+		return nil, headerError(ErrTruncated, t.offset, string(magicNumber), "missing magic number")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading magic number: %w", err)
+	}
+	// Check each error so that a nil pointer is never returned in a non-nil interface
+	var img image.Image
+	switch string(magicNumber) {
+	case "P1", "P4":
+		pbm, err := DecodePBMWithOptions(t, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = pbm
+	case "P2", "P5":
+		pgm, err := DecodePGMWithOptions(t, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = pgm
+	case "P3", "P6":
+		ppm, err := DecodePPMWithOptions(t, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = ppm
+	case "P7":
+		pam, err := DecodePAMWithOptions(t, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = pam
+	default:
+		return nil, headerError(ErrBadMagic, t.offset, string(magicNumber), "")
+	}
+	return img, nil
+}
@@ -0,0 +1,279 @@
+package Netpbm2
+
+import "fmt"
+
+// runningLine stores in out the min, or the max when dilate is true, of the
+// pixels lo to hi around each of the n pixels of a line, reading pixel i at
+// line[i*step]. Pixels beyond the ends are neutral. It uses the algorithm of
+// van Herk and Gil-Werman, whose cost does not depend on hi-lo.
+func runningLine(line, out []uint16, n, step, lo, hi int, neutral uint16, dilate bool, g, h []uint16) {
+	pick := func(a, b uint16) uint16 {
+		if (a < b) != dilate {
+			return a
+		}
+		return b
+	}
+	k := hi - lo + 1
+	// The padded line goes from -k to n+k, cut in blocks of k pixels. g holds
+	// the running result from the start of each block, h from its end.
+	size := n + 2*k
+	at := func(i int) uint16 {
+		i -= k
+		if i < 0 || i >= n {
+			return neutral
+		}
+		return line[i*step]
+	}
+	for i := 0; i < size; i++ {
+		if i%k == 0 {
+			g[i] = at(i)
+		} else {
+			g[i] = pick(g[i-1], at(i))
+		}
+	}
+	for i := size - 1; i >= 0; i-- {
+		if i%k == k-1 || i == size-1 {
+			h[i] = at(i)
+		} else {
+			h[i] = pick(h[i+1], at(i))
+		}
+	}
+	// The window of pixel x goes from x+lo to x+hi, it spans at most two blocks
+	for x := 0; x < n; x++ {
+		first, last := x+lo+k, x+hi+k
+		out[x*step] = pick(h[first], g[last])
+	}
+}
+
+// morphRaster returns r eroded by se, or dilated when dilate is true. Pixels
+// beyond the edges are neutral: the max value for an erosion, 0 for a dilation.
+func morphRaster(r raster, se StructuringElement, dilate bool) raster {
+	neutral := r.max
+	if dilate {
+		neutral = 0
+	}
+	// A dilation takes the max under the element reflected around its origin
+	sign := 1
+	if dilate {
+		sign = -1
+	}
+	dst := newRaster(r.width, r.height, 1, r.max)
+	if se.rect {
+		// Rectangles are a horizontal and a vertical line, each done with running min or max
+		b := se.bounds
+		lo, hi := b.Min.X, b.Max.X-1
+		if dilate {
+			lo, hi = -hi, -lo
+		}
+		k := max(b.Dx(), b.Dy())
+		g := make([]uint16, max(r.width, r.height)+2*k)
+		h := make([]uint16, len(g))
+		tmp := newRaster(r.width, r.height, 1, r.max)
+		for y := 0; y < r.height; y++ {
+			runningLine(r.pix[y*r.stride:], tmp.pix[y*tmp.stride:], r.width, 1, lo, hi, neutral, dilate, g, h)
+		}
+		lo, hi = b.Min.Y, b.Max.Y-1
+		if dilate {
+			lo, hi = -hi, -lo
+		}
+		for x := 0; x < r.width; x++ {
+			runningLine(tmp.pix[x:], dst.pix[x:], r.height, tmp.stride, lo, hi, neutral, dilate, g, h)
+		}
+		return dst
+	}
+	for i := range dst.pix {
+		dst.pix[i] = neutral
+	}
+	for _, p := range se.offsets {
+		dx, dy := sign*p.X, sign*p.Y
+		for y := 0; y < r.height; y++ {
+			out := dst.pix[y*dst.stride : y*dst.stride+r.width]
+			sy := y + dy
+			for x := range out {
+				sx := x + dx
+				v := neutral
+				if sx >= 0 && sx < r.width && sy >= 0 && sy < r.height {
+					v = r.pix[sy*r.stride+sx]
+				}
+				if (v < out[x]) != dilate && v != out[x] {
+					out[x] = v
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// Erode sets each pixel of the PGM image to the darkest of the pixels under
+// se placed on it. Pixels beyond the edges do not count. Rectangles, lines
+// included, cost the same whatever their size. An empty element leaves the
+// image as it is.
+func (pgm *PGM) Erode(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pgm.setRaster(morphRaster(pgm.raster(), se, false))
+}
+
+// Dilate sets each pixel of the PGM image to the lightest of the pixels that
+// se placed on them covers it. Pixels beyond the edges do not count.
+// Rectangles, lines included, cost the same whatever their size. An empty
+// element leaves the image as it is.
+func (pgm *PGM) Dilate(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pgm.setRaster(morphRaster(pgm.raster(), se, true))
+}
+
+// Open erodes then dilates the PGM image by se, which darkens the light
+// details se does not fit in.
+func (pgm *PGM) Open(se StructuringElement) {
+	pgm.Erode(se)
+	pgm.Dilate(se)
+}
+
+// Close dilates then erodes the PGM image by se, which lightens the dark
+// details se does not fit in.
+func (pgm *PGM) Close(se StructuringElement) {
+	pgm.Dilate(se)
+	pgm.Erode(se)
+}
+
+// TopHat replaces the PGM image with its difference with its opening by se,
+// which keeps the light details smaller than se on a black background, such
+// as to even out the lighting of a page.
+func (pgm *PGM) TopHat(se StructuringElement) {
+	opened := pgm.clone()
+	opened.Open(se)
+	result := NewPGM(pgm.width, pgm.height, pgm.max)
+	for y := 0; y < pgm.height; y++ {
+		for x, v := range pgm.row(y) {
+			result.pix[y*result.stride+x] = v - opened.GrayAt(x, y)
+		}
+	}
+	pgm.setRaster(result.raster())
+}
+
+// BlackTopHat replaces the PGM image with the difference between its closing
+// by se and itself, which keeps the dark details smaller than se, as light
+// details on a black background.
+func (pgm *PGM) BlackTopHat(se StructuringElement) {
+	closed := pgm.clone()
+	closed.Close(se)
+	result := NewPGM(pgm.width, pgm.height, pgm.max)
+	for y := 0; y < pgm.height; y++ {
+		for x, v := range pgm.row(y) {
+			result.pix[y*result.stride+x] = closed.GrayAt(x, y) - v
+		}
+	}
+	pgm.setRaster(result.raster())
+}
+
+// Reconstruct replaces the PGM image, the marker, with its morphological
+// reconstruction by dilation under mask: the marker is dilated within mask
+// until it no longer changes, 8-connected pixels being neighbors. It keeps the
+// light regions of mask that the marker touches, such as to fill holes or
+// remove the shapes touching the edges. The marker is first limited to mask.
+func (pgm *PGM) Reconstruct(mask *PGM) error {
+	if mask.width != pgm.width || mask.height != pgm.height {
+		return fmt.Errorf("mask is %dx%d, image is %dx%d", mask.width, mask.height, pgm.width, pgm.height)
+	}
+	width, height := pgm.width, pgm.height
+	j := make([]uint16, width*height)
+	m := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m[y*width+x] = mask.GrayAt(x, y)
+			j[y*width+x] = min(pgm.GrayAt(x, y), m[y*width+x])
+		}
+	}
+	// The neighbors before a pixel in raster order, those after are their opposites
+	before := [4][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}}
+	inside := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < width && y < height
+	}
+
+	// Hybrid algorithm of Vincent: a raster scan, an anti-raster scan that
+	// queues the pixels that can still spread, then a propagation from them
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := j[y*width+x]
+			for _, d := range before {
+				if nx, ny := x+d[0], y+d[1]; inside(nx, ny) {
+					v = max(v, j[ny*width+nx])
+				}
+			}
+			j[y*width+x] = min(v, m[y*width+x])
+		}
+	}
+	var queue []int
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			v := j[y*width+x]
+			for _, d := range before {
+				if nx, ny := x-d[0], y-d[1]; inside(nx, ny) {
+					v = max(v, j[ny*width+nx])
+				}
+			}
+			v = min(v, m[y*width+x])
+			j[y*width+x] = v
+			for _, d := range before {
+				if nx, ny := x-d[0], y-d[1]; inside(nx, ny) {
+					q := ny*width + nx
+					if j[q] < v && j[q] < m[q] {
+						queue = append(queue, y*width+x)
+						break
+					}
+				}
+			}
+		}
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		x, y := p%width, p/width
+		for ny := y - 1; ny <= y+1; ny++ {
+			for nx := x - 1; nx <= x+1; nx++ {
+				if !inside(nx, ny) || (nx == x && ny == y) {
+					continue
+				}
+				q := ny*width + nx
+				if j[q] < j[p] && m[q] != j[q] {
+					j[q] = min(j[p], m[q])
+					queue = append(queue, q)
+				}
+			}
+		}
+	}
+	result := newRaster(width, height, 1, pgm.max)
+	copy(result.pix, j)
+	pgm.setRaster(result)
+	return nil
+}
+
+// ReconstructByErosion replaces the PGM image, the marker, with its
+// morphological reconstruction by erosion above mask, the dual of Reconstruct:
+// the marker is eroded down to mask until it no longer changes. It keeps the
+// dark regions of mask that the marker touches. The marker is first limited
+// to be above mask.
+func (pgm *PGM) ReconstructByErosion(mask *PGM) error {
+	if mask.width != pgm.width || mask.height != pgm.height {
+		return fmt.Errorf("mask is %dx%d, image is %dx%d", mask.width, mask.height, pgm.width, pgm.height)
+	}
+	// Reconstruct the inverted marker under the inverted mask
+	marker := NewPGM(pgm.width, pgm.height, pgm.max)
+	inverted := NewPGM(pgm.width, pgm.height, pgm.max)
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			marker.SetGray(x, y, pgm.max-pgm.GrayAt(x, y))
+			inverted.SetGray(x, y, pgm.max-min(mask.GrayAt(x, y), pgm.max))
+		}
+	}
+	if err := marker.Reconstruct(inverted); err != nil {
+		return err
+	}
+	marker.Invert()
+	pgm.setRaster(marker.raster())
+	return nil
+}
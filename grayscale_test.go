@@ -0,0 +1,218 @@
+package Netpbm2
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// naiveGrayMorphology returns the min, or the max when dilate is true, of the
+// pixels of src at the offsets of se from each pixel, those beyond the edges
+// not counting.
+func naiveGrayMorphology(src *PGM, se StructuringElement, dilate bool) *PGM {
+	width, height := src.Size()
+	dst := NewPGM(width, height, src.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := src.max
+			if dilate {
+				v = 0
+			}
+			for _, p := range se.offsets {
+				// A dilation looks at the pixels under the reflected element
+				if dilate {
+					p = image.Pt(-p.X, -p.Y)
+				}
+				q := image.Pt(x, y).Add(p)
+				if !q.In(image.Rect(0, 0, width, height)) {
+					continue
+				}
+				if s := src.GrayAt(q.X, q.Y); (s < v) != dilate && s != v {
+					v = s
+				}
+			}
+			dst.SetGray(x, y, v)
+		}
+	}
+	return dst
+}
+
+// naiveReconstruct reconstructs marker under mask, or above it when erode is
+// true, by dilating or eroding it by a 3x3 square until it no longer changes.
+func naiveReconstruct(marker, mask *PGM, erode bool) *PGM {
+	width, height := marker.Size()
+	limit := func(v, m uint16) uint16 {
+		if erode {
+			return max(v, m)
+		}
+		return min(v, m)
+	}
+	j := NewPGM(width, height, marker.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			j.SetGray(x, y, limit(marker.GrayAt(x, y), mask.GrayAt(x, y)))
+		}
+	}
+	square := NewRectangleElement(3, 3)
+	for changed := true; changed; {
+		changed = false
+		next := naiveGrayMorphology(j, square, !erode)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				v := limit(next.GrayAt(x, y), mask.GrayAt(x, y))
+				if v != j.GrayAt(x, y) {
+					j.SetGray(x, y, v)
+					changed = true
+				}
+			}
+		}
+	}
+	return j
+}
+
+// randomPGM returns a PGM image of the given size whose pixels take levels
+// different values from 0 to max.
+func randomPGM(rng *rand.Rand, width, height int, max uint16, levels int) *PGM {
+	pgm := NewPGM(width, height, max)
+	for i := range pgm.pix {
+		pgm.pix[i] = uint16(rng.Intn(levels) * int(max) / (levels - 1))
+	}
+	return pgm
+}
+
+// comparePGM fails t unless got and want have the same size and pixels.
+func comparePGM(t *testing.T, name string, got, want *PGM) {
+	t.Helper()
+	gw, gh := got.Size()
+	ww, wh := want.Size()
+	if gw != ww || gh != wh {
+		t.Fatalf("%s: got %dx%d, want %dx%d", name, gw, gh, ww, wh)
+	}
+	for y := 0; y < wh; y++ {
+		for x := 0; x < ww; x++ {
+			if got.GrayAt(x, y) != want.GrayAt(x, y) {
+				t.Fatalf("%s: pixel (%d, %d) is %d, want %d", name, x, y, got.GrayAt(x, y), want.GrayAt(x, y))
+			}
+		}
+	}
+}
+
+// grayTestImages returns random images of various sizes and max values, and a
+// view of a larger one.
+func grayTestImages(rng *rand.Rand) map[string]*PGM {
+	images := map[string]*PGM{
+		"1x1":           randomPGM(rng, 1, 1, 255, 256),
+		"7x5":           randomPGM(rng, 7, 5, 255, 256),
+		"33x17":         randomPGM(rng, 33, 17, 65535, 65536),
+		"16x16 levels":  randomPGM(rng, 16, 16, 255, 4),
+		"view of 40x30": randomPGM(rng, 40, 30, 1000, 1001).SubImage(image.Rect(5, 3, 31, 22)),
+	}
+	return images
+}
+
+func TestPGMErodeDilateRectangles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sizes := []image.Point{{1, 1}, {2, 2}, {3, 3}, {4, 3}, {1, 6}, {9, 1}, {5, 8}, {40, 3}}
+	for imageName, src := range grayTestImages(rng) {
+		for _, size := range sizes {
+			rect := NewRectangleElement(size.X, size.Y)
+			// The same pixels without the rectangle flag take the generic path
+			generic := newElement(rect.offsets)
+			for _, dilate := range []bool{false, true} {
+				name := imageName + " eroded by " + size.String()
+				if dilate {
+					name = imageName + " dilated by " + size.String()
+				}
+				want := naiveGrayMorphology(src, rect, dilate)
+				got, gotGeneric := src.clone(), src.clone()
+				if dilate {
+					got.Dilate(rect)
+					gotGeneric.Dilate(generic)
+				} else {
+					got.Erode(rect)
+					gotGeneric.Erode(generic)
+				}
+				comparePGM(t, name, got, want)
+				comparePGM(t, name+" without the rectangle path", gotGeneric, want)
+			}
+		}
+	}
+}
+
+func TestPGMErodeDilate(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for imageName, src := range grayTestImages(rng) {
+		for seName, se := range testElements() {
+			want := naiveGrayMorphology(src, se, false)
+			got := src.clone()
+			got.Erode(se)
+			comparePGM(t, imageName+" eroded by "+seName, got, want)
+			want = naiveGrayMorphology(src, se, true)
+			got = src.clone()
+			got.Dilate(se)
+			comparePGM(t, imageName+" dilated by "+seName, got, want)
+		}
+	}
+}
+
+func TestPGMReconstruct(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		width, height := 1+rng.Intn(30), 1+rng.Intn(30)
+		mask := randomPGM(rng, width, height, 255, 4)
+		// Sparse markers, so that the reconstruction has to spread far
+		marker := NewPGM(width, height, 255)
+		for j := 0; j < 3; j++ {
+			marker.SetGray(rng.Intn(width), rng.Intn(height), uint16(rng.Intn(256)))
+		}
+		got := marker.clone()
+		if err := got.Reconstruct(mask); err != nil {
+			t.Fatal(err)
+		}
+		comparePGM(t, "reconstruction by dilation", got, naiveReconstruct(marker, mask, false))
+
+		marker = NewPGM(width, height, 255)
+		marker.Invert()
+		for j := 0; j < 3; j++ {
+			marker.SetGray(rng.Intn(width), rng.Intn(height), uint16(rng.Intn(256)))
+		}
+		got = marker.clone()
+		if err := got.ReconstructByErosion(mask); err != nil {
+			t.Fatal(err)
+		}
+		comparePGM(t, "reconstruction by erosion", got, naiveReconstruct(marker, mask, true))
+	}
+	if err := NewPGM(3, 3, 255).Reconstruct(NewPGM(3, 4, 255)); err == nil {
+		t.Error("no error for a mask of another size")
+	}
+}
+
+func TestPGMTopHatSubImage(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	parent := randomPGM(rng, 30, 20, 255, 256)
+	original := parent.clone()
+	r := image.Rect(4, 3, 25, 17)
+	se := NewDiskElement(2)
+	for _, black := range []bool{false, true} {
+		view := parent.SubImage(r)
+		want := view.clone()
+		if black {
+			want.Close(se)
+			view.BlackTopHat(se)
+		} else {
+			want.Open(se)
+			view.TopHat(se)
+		}
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				v, w := parent.GrayAt(r.Min.X+x, r.Min.Y+y), want.GrayAt(x, y)
+				if black {
+					v, w = w, v
+				}
+				want.SetGray(x, y, v-w)
+			}
+		}
+		comparePGM(t, "top-hat of a view", view, want)
+		comparePGM(t, "parent of the view", parent, original)
+	}
+}
@@ -0,0 +1,284 @@
+package Netpbm2
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Make sure the image types can be used with image/draw.
+var (
+	_ draw.Image = (*PBM)(nil)
+	_ draw.Image = (*PGM)(nil)
+	_ draw.Image = (*PPM)(nil)
+	_ draw.Image = (*PAM)(nil)
+)
+
+// BitModel is the color model of a PBM image. Colors darker than middle gray
+// become black and the others white.
+var BitModel color.Model = color.ModelFunc(bitModel)
+
+func bitModel(c color.Color) color.Color {
+	if isBlack(c) {
+		return color.Gray{0}
+	}
+	return color.Gray{0xff}
+}
+
+// isBlack reports whether c is closer to black than to white.
+func isBlack(c color.Color) bool {
+	return color.Gray16Model.Convert(c).(color.Gray16).Y < 0x8000
+}
+
+// scaleTo16 converts a sample going from 0 to max into one going from 0 to 0xffff.
+func scaleTo16(value, max int) uint16 {
+	return uint16((value*0xffff + max/2) / max)
+}
+
+// scaleFrom16 converts a sample going from 0 to 0xffff into one going from 0 to max.
+func scaleFrom16(value uint32, max int) int {
+	return int((value*uint32(max) + 0x7fff) / 0xffff)
+}
+
+// rescale converts a sample going from 0 to from into one going from 0 to to,
+// rounding to the nearest.
+func rescale(value, from, to uint16) uint16 {
+	return uint16((uint(value)*uint(to) + uint(from)/2) / uint(from))
+}
+
+// ColorModel returns BitModel.
+func (pbm *PBM) ColorModel() color.Model {
+	return BitModel
+}
+
+// Bounds returns the rectangle going from (0, 0) to (width, height).
+func (pbm *PBM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pbm.width, pbm.height)
+}
+
+// At returns black or white as a color.Gray. Pixels outside the image are white.
+func (pbm *PBM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pbm.Bounds())) || !pbm.BitAt(x, y) {
+		return color.Gray{0xff}
+	}
+	return color.Gray{0}
+}
+
+// Set sets the pixel at (x, y) to black if c is closer to black than to white.
+func (pbm *PBM) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(pbm.Bounds())) {
+		return
+	}
+	pbm.SetBit(x, y, isBlack(c))
+}
+
+// ColorModel returns color.GrayModel, or color.Gray16Model when the max value
+// is above 255.
+func (pgm *PGM) ColorModel() color.Model {
+	if pgm.max > 255 {
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+// Bounds returns the rectangle going from (0, 0) to (width, height).
+func (pgm *PGM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pgm.width, pgm.height)
+}
+
+// At returns the pixel at (x, y) as a color.Gray, or a color.Gray16 when the
+// max value is above 255, scaled from the max value of the image.
+func (pgm *PGM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pgm.Bounds())) {
+		return pgm.ColorModel().Convert(color.Black)
+	}
+	gray := scaleTo16(int(pgm.pix[y*pgm.stride+x]), int(pgm.max))
+	if pgm.max > 255 {
+		return color.Gray16{gray}
+	}
+	return color.Gray{uint8(gray >> 8)}
+}
+
+// Set converts c to gray and stores it scaled to the max value of the image.
+func (pgm *PGM) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(pgm.Bounds())) {
+		return
+	}
+	gray := color.Gray16Model.Convert(c).(color.Gray16)
+	pgm.pix[y*pgm.stride+x] = uint16(scaleFrom16(uint32(gray.Y), int(pgm.max)))
+}
+
+// ColorModel returns color.RGBAModel, or color.RGBA64Model when the max value
+// is above 255.
+func (ppm *PPM) ColorModel() color.Model {
+	if ppm.max > 255 {
+		return color.RGBA64Model
+	}
+	return color.RGBAModel
+}
+
+// Bounds returns the rectangle going from (0, 0) to (width, height).
+func (ppm *PPM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, ppm.width, ppm.height)
+}
+
+// At returns the pixel at (x, y) as an opaque color.RGBA, or a color.RGBA64
+// when the max value is above 255, scaled from the max value of the image.
+func (ppm *PPM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(ppm.Bounds())) {
+		return ppm.ColorModel().Convert(color.Transparent)
+	}
+	pixel := ppm.PixelAt(x, y)
+	max := int(ppm.max)
+	r, g, b := scaleTo16(int(pixel.R), max), scaleTo16(int(pixel.G), max), scaleTo16(int(pixel.B), max)
+	if ppm.max > 255 {
+		return color.RGBA64{r, g, b, 0xffff}
+	}
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+}
+
+// Set stores c scaled to the max value of the image. Translucent colors are
+// treated as if drawn over black.
+func (ppm *PPM) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(ppm.Bounds())) {
+		return
+	}
+	r, g, b, _ := c.RGBA()
+	max := int(ppm.max)
+	ppm.SetPixel(x, y, Pixel{
+		R: uint16(scaleFrom16(r, max)),
+		G: uint16(scaleFrom16(g, max)),
+		B: uint16(scaleFrom16(b, max)),
+	})
+}
+
+// NewPBMFromImage returns a PBM image holding img converted to black and white.
+func NewPBMFromImage(img image.Image) *PBM {
+	bounds := img.Bounds()
+	pbm := NewPBM(bounds.Dx(), bounds.Dy())
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			pbm.SetBit(x, y, isBlack(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	return pbm
+}
+
+// is16Bit reports whether m is one of the color models of the standard library
+// holding 16 bits per sample.
+func is16Bit(m color.Model) bool {
+	switch m {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model, color.Alpha16Model:
+		return true
+	}
+	return false
+}
+
+// maxValueOf returns the max value that keeps every sample of img: 65535 for
+// images with 16 bits per sample and 255 for the others.
+func maxValueOf(img image.Image) uint16 {
+	if is16Bit(img.ColorModel()) {
+		return 65535
+	}
+	return 255
+}
+
+// NewPGMFromImage returns a PGM image holding img converted to gray, with a
+// max value of 65535 when img has 16 bits per sample and 255 otherwise.
+func NewPGMFromImage(img image.Image) *PGM {
+	bounds := img.Bounds()
+	pgm := NewPGM(bounds.Dx(), bounds.Dy(), maxValueOf(img))
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			pgm.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return pgm
+}
+
+// NewPPMFromImage returns a PPM image holding img, with a max value of 65535
+// when img has 16 bits per sample and 255 otherwise.
+func NewPPMFromImage(img image.Image) *PPM {
+	bounds := img.Bounds()
+	ppm := NewPPM(bounds.Dx(), bounds.Dy(), maxValueOf(img))
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			ppm.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return ppm
+}
+
+// ColorModel returns color.NRGBAModel, or color.NRGBA64Model when the max
+// value is above 255.
+func (pam *PAM) ColorModel() color.Model {
+	if pam.max > 255 {
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+// Bounds returns the rectangle going from (0, 0) to (width, height).
+func (pam *PAM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pam.width, pam.height)
+}
+
+// At returns the pixel at (x, y) as a color.NRGBA, or a color.NRGBA64 when
+// the max value is above 255, scaled from the max value of the image. Tuples
+// without opacity are opaque.
+func (pam *PAM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pam.Bounds())) {
+		return pam.ColorModel().Convert(color.Transparent)
+	}
+	tuple := pam.TupleAt(x, y)
+	max := int(pam.max)
+	c := color.NRGBA64{A: 0xffff}
+	if pam.colorDepth() >= 3 {
+		c.R, c.G, c.B = scaleTo16(int(tuple[0]), max), scaleTo16(int(tuple[1]), max), scaleTo16(int(tuple[2]), max)
+	} else {
+		c.R = scaleTo16(int(tuple[0]), max)
+		c.G, c.B = c.R, c.R
+	}
+	if pam.hasAlpha() {
+		c.A = scaleTo16(int(tuple[pam.depth-1]), max)
+	}
+	if pam.max > 255 {
+		return c
+	}
+	return color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+}
+
+// Set stores c in the pixel at (x, y), converted to the tuple type of the
+// image and scaled to its max value.
+func (pam *PAM) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(pam.Bounds())) {
+		return
+	}
+	nrgba := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	max := int(pam.max)
+	tuple := pam.TupleAt(x, y)
+	if pam.colorDepth() >= 3 {
+		tuple[0] = uint16(scaleFrom16(uint32(nrgba.R), max))
+		tuple[1] = uint16(scaleFrom16(uint32(nrgba.G), max))
+		tuple[2] = uint16(scaleFrom16(uint32(nrgba.B), max))
+	} else {
+		gray := color.Gray16Model.Convert(color.NRGBA64{nrgba.R, nrgba.G, nrgba.B, 0xffff}).(color.Gray16)
+		tuple[0] = uint16(scaleFrom16(uint32(gray.Y), max))
+	}
+	if pam.hasAlpha() {
+		tuple[pam.depth-1] = uint16(scaleFrom16(uint32(nrgba.A), max))
+	}
+}
+
+// NewPAMFromImage returns an RGB_ALPHA PAM image holding img, with a max value
+// of 65535 when img has 16 bits per sample and 255 otherwise.
+func NewPAMFromImage(img image.Image) *PAM {
+	bounds := img.Bounds()
+	pam := NewPAM(bounds.Dx(), bounds.Dy(), 4, maxValueOf(img), TupleRGBAlpha)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			pam.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return pam
+}
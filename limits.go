@@ -0,0 +1,103 @@
+package Netpbm2
+
+import (
+	"fmt"
+	"math"
+)
+
+// DecodeOptions limits the images a reader accepts, so that untrusted input
+// cannot make it allocate or read more than wanted. The limits are checked
+// right after the header, and the pixels then grow as the raster is read, so a
+// header claiming a huge image only costs the memory of the data really there.
+// A zero field means no limit, but an image whose pixels cannot be allocated
+// is always rejected, even with nil options.
+type DecodeOptions struct {
+	// MaxWidth and MaxHeight limit the dimensions of the image.
+	MaxWidth, MaxHeight int
+	// MaxPixels limits width*height.
+	MaxPixels int64
+	// MaxBytes limits the number of bytes read for one image, header included.
+	MaxBytes int64
+}
+
+// The limits of DefaultDecodeOptions: 256 megapixels, and 1 GiB read for one
+// image, which keeps the pixels of any accepted image under 2 GiB.
+const (
+	defaultMaxPixels = 1 << 28
+	defaultMaxBytes  = 1 << 30
+)
+
+// maxAlloc is the largest pixel buffer, in bytes, that a reader allocates
+// whatever its options: 1 GiB on 32-bit platforms and 128 TiB on 64-bit ones,
+// both below what the runtime can allocate.
+const maxAlloc = 1<<30 - 1 | math.MaxInt>>16
+
+// DefaultDecodeOptions returns the limits used by the functions that take no
+// options, such as ReadPPM, DecodePPM, Decode and image.Decode: at most
+// 256 megapixels and 1 GiB of data per image. Pass a changed copy to the
+// WithOptions functions to use other limits.
+func DefaultDecodeOptions() *DecodeOptions {
+	return &DecodeOptions{MaxPixels: defaultMaxPixels, MaxBytes: defaultMaxBytes}
+}
+
+// limitError returns an ErrLimitExceeded FormatError.
+func limitError(offset int64, format string, args ...interface{}) *FormatError {
+	return headerError(ErrLimitExceeded, offset, "", fmt.Sprintf(format, args...))
+}
+
+// start prepares t to read one image under the limits of opts. It must be
+// called before the header is read so that MaxBytes counts it.
+func (opts *DecodeOptions) start(t *tokenizer) {
+	t.limit = 0
+	if opts != nil && opts.MaxBytes > 0 {
+		t.limit = t.offset + opts.MaxBytes
+	}
+}
+
+// allocBytes returns the size in bytes of the pixels of an image of
+// width*height pixels of samples values of bits bits each, with every row
+// starting on a byte, or -1 when it is above maxAlloc.
+func allocBytes(width, height, samples, bits int) int64 {
+	if width <= 0 || height <= 0 || samples <= 0 {
+		return 0
+	}
+	limit := int64(maxAlloc)
+	if int64(samples) > limit*8/int64(bits) {
+		return -1
+	}
+	pixelBits := int64(samples) * int64(bits)
+	if int64(width) > limit*8/pixelBits {
+		return -1
+	}
+	row := (int64(width)*pixelBits + 7) / 8
+	if int64(height) > limit/row {
+		return -1
+	}
+	return row * int64(height)
+}
+
+// check returns an error if an image of width*height pixels of samples values
+// each, stored on bits bits in memory and whose raster takes at least
+// rasterBytes, goes beyond the limits of opts or cannot be allocated at all.
+func (opts *DecodeOptions) check(t *tokenizer, width, height, samples, bits int, rasterBytes int64) error {
+	// The pixels must be allocatable whatever the limits
+	if allocBytes(width, height, samples, bits) < 0 {
+		return limitError(t.offset, "image of %dx%d with %d samples per pixel is too large", width, height, samples)
+	}
+	if opts == nil {
+		return nil
+	}
+	if opts.MaxWidth > 0 && width > opts.MaxWidth {
+		return limitError(t.offset, "width %d is above %d", width, opts.MaxWidth)
+	}
+	if opts.MaxHeight > 0 && height > opts.MaxHeight {
+		return limitError(t.offset, "height %d is above %d", height, opts.MaxHeight)
+	}
+	if opts.MaxPixels > 0 && int64(width)*int64(height) > opts.MaxPixels {
+		return limitError(t.offset, "%dx%d pixels are above %d", width, height, opts.MaxPixels)
+	}
+	if t.limit > 0 && t.offset+rasterBytes > t.limit {
+		return limitError(t.offset, "image takes at least %d bytes, above %d", t.offset+rasterBytes-(t.limit-opts.MaxBytes), opts.MaxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package Netpbm2
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  *DecodeOptions
+		want  error
+	}{
+		{"within the limits", "P5 2 2 255\nABCD", &DecodeOptions{MaxWidth: 2, MaxHeight: 2, MaxPixels: 4, MaxBytes: 15}, nil},
+		{"width", "P5 3 2 255\nABCDEF", &DecodeOptions{MaxWidth: 2}, ErrLimitExceeded},
+		{"height", "P2 2 3 255\n1 2 3 4 5 6", &DecodeOptions{MaxHeight: 2}, ErrLimitExceeded},
+		{"pixels", "P4 8 3\nABC", &DecodeOptions{MaxPixels: 20}, ErrLimitExceeded},
+		{"bytes claimed by the header", "P6 2 2 255\n", &DecodeOptions{MaxBytes: 20}, ErrLimitExceeded},
+		{"bytes of a plain raster", "P2 2 1 255\n1          2", &DecodeOptions{MaxBytes: 16}, ErrLimitExceeded},
+		{"PAM pixels", "P7\nWIDTH 5\nHEIGHT 5\nDEPTH 1\nMAXVAL 255\nENDHDR\n", &DecodeOptions{MaxPixels: 24}, ErrLimitExceeded},
+		{"no options", "P5 100000000 100000000 255\n", nil, ErrLimitExceeded},
+		{"samples overflowing an int", "P5 3037000499 3037000499 255\n", nil, ErrLimitExceeded},
+		{"PAM depth overflowing an int", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4611686018427387904\nMAXVAL 255\nENDHDR\n", nil, ErrLimitExceeded},
+		{"default options", "P6 100000 100000 255\n", DefaultDecodeOptions(), ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWithOptions(strings.NewReader(test.input), test.opts)
+			if test.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// TestDecodeClaimedSize checks that a header claiming a huge image within the
+// default limits only costs the memory of the data that follows it.
+func TestDecodeClaimedSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		decode func(io.Reader) error
+	}{
+		{"PBM raw", "P4 16384 16384\n\x00", decodePBM},
+		{"PBM plain", "P1 16384 16384\n0 1", decodePBM},
+		{"PGM raw", "P5 16384 16384 65535\n\x00\x00", decodePGM},
+		{"PGM plain", "P2 16384 16384 255\n1 2", decodePGM},
+		{"PPM raw", "P6 16384 16384 255\nABC", decodePPM},
+		{"PPM plain", "P3 16384 16384 255\n1 2 3", decodePPM},
+		{"PAM depth", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1000000000\nMAXVAL 255\nENDHDR\nA", decodePAM},
+		{"PFM", "PF 8192 8192 -1\n\x00\x00\x80\x3f", decodePFM},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			err := test.decode(strings.NewReader(test.input))
+			runtime.ReadMemStats(&after)
+			if !errors.Is(err, ErrTruncated) {
+				t.Errorf("got error %v, want %v", err, ErrTruncated)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("allocated %d bytes for a %d-byte image", allocated, len(test.input))
+			}
+		})
+	}
+}
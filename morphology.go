@@ -0,0 +1,241 @@
+package Netpbm2
+
+// bitmap holds a binary image packed 64 pixels per word, the leftmost pixel in
+// the most significant bit, so that morphology works on 64 pixels at once.
+type bitmap struct {
+	words         []uint64
+	stride        int
+	width, height int
+	// outside is the value of every pixel beyond the edges, 0 or all ones.
+	// The padding bits at the end of each row always hold it.
+	outside uint64
+}
+
+// newBitmap returns a bitmap whose pixels, inside and outside, are all fill.
+func newBitmap(width, height int, fill uint64) *bitmap {
+	b := &bitmap{stride: (width + 63) / 64, width: width, height: height, outside: fill}
+	b.words = make([]uint64, b.stride*height)
+	if fill != 0 {
+		for i := range b.words {
+			b.words[i] = fill
+		}
+	}
+	return b
+}
+
+// padding returns the mask of the padding bits of the last word of a row.
+func (b *bitmap) padding() uint64 {
+	if b.width%64 == 0 {
+		return 0
+	}
+	return ^uint64(0) >> (b.width % 64)
+}
+
+// bitmap returns the black pixels of the PBM image, white being outside.
+func (pbm *PBM) bitmap() *bitmap {
+	b := newBitmap(pbm.width, pbm.height, 0)
+	for y := 0; y < pbm.height; y++ {
+		row := b.words[y*b.stride:]
+		if pbm.bitOffset == 0 {
+			src := pbm.pix[y*pbm.stride : y*pbm.stride+(pbm.width+7)/8]
+			for i, v := range src {
+				row[i/8] |= uint64(v) << (56 - 8*(i%8))
+			}
+		} else {
+			for x := 0; x < pbm.width; x++ {
+				if pbm.BitAt(x, y) {
+					row[x/64] |= 1 << (63 - x%64)
+				}
+			}
+		}
+		if b.stride > 0 {
+			row[b.stride-1] &^= b.padding()
+		}
+	}
+	return b
+}
+
+// setBitmap replaces the pixels of the PBM image with b, set bits being black.
+func (pbm *PBM) setBitmap(b *bitmap) {
+	dst := NewPBM(b.width, b.height)
+	for y := 0; y < b.height; y++ {
+		row := b.words[y*b.stride:]
+		out := dst.pix[y*dst.stride : (y+1)*dst.stride]
+		for i := range out {
+			out[i] = byte(row[i/8] >> (56 - 8*(i%8)))
+		}
+		if b.width%8 != 0 {
+			out[len(out)-1] &= 0xff << (8 - b.width%8)
+		}
+	}
+	dst.magicNumber = pbm.magicNumber
+	*pbm = *dst
+}
+
+// shift stores in dst the bitmap b moved so that the pixel (x, y) of dst is
+// the pixel (x+dx, y+dy) of b.
+func (b *bitmap) shift(dst *bitmap, dx, dy int) {
+	dst.outside = b.outside
+	q, r := dx>>6, uint(dx&63)
+	word := func(row []uint64, i int) uint64 {
+		if i < 0 || i >= b.stride {
+			return b.outside
+		}
+		return row[i]
+	}
+	for y := 0; y < b.height; y++ {
+		out := dst.words[y*dst.stride : (y+1)*dst.stride]
+		sy := y + dy
+		if sy < 0 || sy >= b.height {
+			for i := range out {
+				out[i] = b.outside
+			}
+			continue
+		}
+		row := b.words[sy*b.stride : (sy+1)*b.stride]
+		for i := range out {
+			// q and r are such that dx = 64*q + r with r from 0 to 63
+			v := word(row, i+q) << r
+			if r != 0 {
+				v |= word(row, i+q+1) >> (64 - r)
+			}
+			out[i] = v
+		}
+		if len(out) > 0 {
+			p := b.padding()
+			out[len(out)-1] = out[len(out)-1]&^p | b.outside&p
+		}
+	}
+}
+
+// combine sets b to op(b, o) word by word, outside included.
+func (b *bitmap) combine(o *bitmap, op func(x, y uint64) uint64) {
+	for i := range b.words {
+		b.words[i] = op(b.words[i], o.words[i])
+	}
+	b.outside = op(b.outside, o.outside)
+}
+
+func and(x, y uint64) uint64    { return x & y }
+func or(x, y uint64) uint64     { return x | y }
+func andNot(x, y uint64) uint64 { return x &^ y }
+
+// not returns the complement of b.
+func (b *bitmap) not() *bitmap {
+	c := &bitmap{words: make([]uint64, len(b.words)), stride: b.stride, width: b.width, height: b.height, outside: ^b.outside}
+	for i, v := range b.words {
+		c.words[i] = ^v
+	}
+	return c
+}
+
+// erode returns b eroded by se: a pixel is set when all the pixels under se
+// placed on it are set.
+func (b *bitmap) erode(se StructuringElement) *bitmap {
+	if se.rect {
+		row, column := se.lines()
+		return b.erode(row).erode(column)
+	}
+	result := newBitmap(b.width, b.height, ^uint64(0))
+	tmp := newBitmap(b.width, b.height, 0)
+	for _, p := range se.offsets {
+		b.shift(tmp, p.X, p.Y)
+		result.combine(tmp, and)
+	}
+	return result
+}
+
+// dilate returns b dilated by se: a pixel is set when se placed on a set pixel
+// covers it.
+func (b *bitmap) dilate(se StructuringElement) *bitmap {
+	if se.rect {
+		row, column := se.lines()
+		return b.dilate(row).dilate(column)
+	}
+	result := newBitmap(b.width, b.height, 0)
+	tmp := newBitmap(b.width, b.height, 0)
+	for _, p := range se.offsets {
+		b.shift(tmp, -p.X, -p.Y)
+		result.combine(tmp, or)
+	}
+	return result
+}
+
+// Erode erodes the black pixels of the PBM image by se: a pixel stays black
+// only when all the pixels under se placed on it are black. Pixels beyond the
+// edges are white. An empty element leaves the image as it is.
+func (pbm *PBM) Erode(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pbm.setBitmap(pbm.bitmap().erode(se))
+}
+
+// Dilate dilates the black pixels of the PBM image by se: a pixel becomes
+// black when se placed on a black pixel covers it. An empty element leaves the
+// image as it is.
+func (pbm *PBM) Dilate(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pbm.setBitmap(pbm.bitmap().dilate(se))
+}
+
+// Open erodes then dilates the PBM image by se, which removes the black
+// details se does not fit in, such as specks.
+func (pbm *PBM) Open(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pbm.setBitmap(pbm.bitmap().erode(se).dilate(se))
+}
+
+// Close dilates then erodes the PBM image by se, which fills the white details
+// se does not fit in, such as holes and gaps in strokes.
+func (pbm *PBM) Close(se StructuringElement) {
+	if se.Len() == 0 {
+		return
+	}
+	pbm.setBitmap(pbm.bitmap().dilate(se).erode(se))
+}
+
+// HitOrMiss keeps black the pixels of the PBM image where hit placed on them
+// only covers black pixels and miss only covers white ones, which finds the
+// places matching a pattern. Pixels beyond the edges are white.
+func (pbm *PBM) HitOrMiss(hit, miss StructuringElement) {
+	b := pbm.bitmap()
+	result := newBitmap(b.width, b.height, ^uint64(0))
+	if hit.Len() > 0 {
+		result = b.erode(hit)
+	}
+	if miss.Len() > 0 {
+		result.combine(b.not().erode(miss), and)
+	}
+	pbm.setBitmap(result)
+}
+
+// TopHat keeps black the pixels of the PBM image that opening by se makes
+// white: the black details smaller than se.
+func (pbm *PBM) TopHat(se StructuringElement) {
+	b := pbm.bitmap()
+	b.combine(b.erode(se).dilate(se), andNot)
+	pbm.setBitmap(b)
+}
+
+// BlackTopHat makes black the pixels of the PBM image that closing by se makes
+// black: the white details smaller than se.
+func (pbm *PBM) BlackTopHat(se StructuringElement) {
+	b := pbm.bitmap()
+	closed := b.dilate(se).erode(se)
+	closed.combine(b, andNot)
+	pbm.setBitmap(closed)
+}
+
+// MorphologicalGradient keeps black the pixels of the PBM image that dilating
+// by se makes black or eroding by se makes white: the outlines of the shapes.
+func (pbm *PBM) MorphologicalGradient(se StructuringElement) {
+	b := pbm.bitmap()
+	dilated := b.dilate(se)
+	dilated.combine(b.erode(se), andNot)
+	pbm.setBitmap(dilated)
+}
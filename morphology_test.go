@@ -0,0 +1,191 @@
+package Netpbm2
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// naiveMorphology returns the image whose pixel (x, y) is black when keep
+// returns true for it, given the pixels of src at the offsets of se from it.
+// Pixels beyond the edges of src are white.
+func naiveMorphology(src *PBM, se StructuringElement, keep func(bits []bool) bool) *PBM {
+	width, height := src.Size()
+	dst := NewPBM(width, height)
+	bits := make([]bool, se.Len())
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for i, p := range se.offsets {
+				q := image.Pt(x, y).Add(p)
+				bits[i] = q.In(image.Rect(0, 0, width, height)) && src.BitAt(q.X, q.Y)
+			}
+			dst.SetBit(x, y, keep(bits))
+		}
+	}
+	return dst
+}
+
+// all reports whether every bit is set.
+func all(bits []bool) bool {
+	for _, bit := range bits {
+		if !bit {
+			return false
+		}
+	}
+	return true
+}
+
+// none reports whether no bit is set.
+func none(bits []bool) bool {
+	for _, bit := range bits {
+		if bit {
+			return false
+		}
+	}
+	return true
+}
+
+func naiveErode(src *PBM, se StructuringElement) *PBM {
+	return naiveMorphology(src, se, all)
+}
+
+func naiveDilate(src *PBM, se StructuringElement) *PBM {
+	// Dilating by se looks at the pixels under its reflection
+	reflected := make([]image.Point, se.Len())
+	for i, p := range se.offsets {
+		reflected[i] = image.Pt(-p.X, -p.Y)
+	}
+	return naiveMorphology(src, newElement(reflected), func(bits []bool) bool { return !none(bits) })
+}
+
+func naiveHitOrMiss(src *PBM, hit, miss StructuringElement) *PBM {
+	hits := naiveErode(src, hit)
+	misses := naiveMorphology(src, miss, none)
+	width, height := src.Size()
+	dst := NewPBM(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.SetBit(x, y, hits.BitAt(x, y) && misses.BitAt(x, y))
+		}
+	}
+	return dst
+}
+
+// copyPBM returns a copy of pbm, which may be a view, with pixels of its own.
+func copyPBM(pbm *PBM) *PBM {
+	width, height := pbm.Size()
+	dst := NewPBM(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.SetBit(x, y, pbm.BitAt(x, y))
+		}
+	}
+	return dst
+}
+
+// comparePBM fails t unless got and want have the same size and pixels.
+func comparePBM(t *testing.T, name string, got, want *PBM) {
+	t.Helper()
+	gw, gh := got.Size()
+	ww, wh := want.Size()
+	if gw != ww || gh != wh {
+		t.Fatalf("%s: got %dx%d, want %dx%d", name, gw, gh, ww, wh)
+	}
+	for y := 0; y < wh; y++ {
+		for x := 0; x < ww; x++ {
+			if got.BitAt(x, y) != want.BitAt(x, y) {
+				t.Fatalf("%s: pixel (%d, %d) is %v, want %v", name, x, y, got.BitAt(x, y), want.BitAt(x, y))
+			}
+		}
+	}
+}
+
+// testElements returns elements of every kind, centered or not, some wider
+// than a word.
+func testElements() map[string]StructuringElement {
+	// An L whose origin is its bottom-left corner, and a pair of pixels whose
+	// origin is outside them
+	l := NewPBM(3, 3)
+	for i := 0; i < 3; i++ {
+		l.SetBit(0, i, true)
+		l.SetBit(i, 2, true)
+	}
+	pair := NewPBM(4, 2)
+	pair.SetBit(0, 0, true)
+	pair.SetBit(3, 1, true)
+	return map[string]StructuringElement{
+		"square 3":       NewRectangleElement(3, 3),
+		"rectangle 4x2":  NewRectangleElement(4, 2),
+		"line 1x5":       NewRectangleElement(1, 5),
+		"line 67x1":      NewRectangleElement(67, 1),
+		"cross 2":        NewCrossElement(2),
+		"disk 3":         NewDiskElement(3),
+		"L":              NewElementFromPBM(l, image.Pt(0, 2)),
+		"pair":           NewElementFromPBM(pair, image.Pt(6, -1)),
+		"single pixel":   NewRectangleElement(1, 1),
+		"shifted pixel":  newElement([]image.Point{{X: 65, Y: 1}}),
+		"wide rectangle": NewRectangleElement(70, 2),
+	}
+}
+
+// testImages returns random images whose widths are and are not multiples of
+// 64, and views of a larger image whose left edges are not on a byte.
+func testImages(rng *rand.Rand) map[string]*PBM {
+	images := make(map[string]*PBM)
+	for _, width := range []int{1, 7, 63, 64, 65, 130} {
+		pbm := NewPBM(width, 7)
+		for y := 0; y < 7; y++ {
+			for x := 0; x < width; x++ {
+				pbm.SetBit(x, y, rng.Intn(5) < 3)
+			}
+		}
+		images[image.Pt(width, 7).String()] = pbm
+	}
+	for _, r := range []image.Rectangle{image.Rect(3, 1, 70, 8), image.Rect(13, 0, 141, 9), image.Rect(61, 2, 62, 6)} {
+		parent := NewPBM(150, 9)
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 150; x++ {
+				parent.SetBit(x, y, rng.Intn(5) < 3)
+			}
+		}
+		images["view "+r.String()] = parent.SubImage(r)
+	}
+	return images
+}
+
+func TestPBMErodeDilate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for imageName, src := range testImages(rng) {
+		original := copyPBM(src)
+		for seName, se := range testElements() {
+			name := imageName + " by " + seName
+			want := naiveErode(original, se)
+			got := src.SubImage(src.Bounds())
+			got.Erode(se)
+			comparePBM(t, "erode "+name, got, want)
+			want = naiveDilate(original, se)
+			got = src.SubImage(src.Bounds())
+			got.Dilate(se)
+			comparePBM(t, "dilate "+name, got, want)
+			comparePBM(t, "source of "+name, src, original)
+		}
+	}
+}
+
+func TestPBMHitOrMiss(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	elements := testElements()
+	pairs := [][2]string{{"single pixel", "cross 2"}, {"L", "pair"}, {"pair", "single pixel"}, {"square 3", "shifted pixel"}}
+	for imageName, src := range testImages(rng) {
+		original := copyPBM(src)
+		for _, pair := range pairs {
+			hit, miss := elements[pair[0]], elements[pair[1]]
+			name := imageName + " by " + pair[0] + " and " + pair[1]
+			want := naiveHitOrMiss(original, hit, miss)
+			got := src.SubImage(src.Bounds())
+			got.HitOrMiss(hit, miss)
+			comparePBM(t, "hit-or-miss "+name, got, want)
+			comparePBM(t, "source of "+name, src, original)
+		}
+	}
+}
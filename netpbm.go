@@ -0,0 +1,238 @@
+package Netpbm2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+)
+
+// Image is implemented by PBM, PGM and PPM images, so that they can be handled
+// the same way whatever their format.
+type Image interface {
+	draw.Image
+	// Size returns the width and height of the image.
+	Size() (int, int)
+	// MagicNumber returns the magic number the image is written with.
+	MagicNumber() string
+	// SetMagicNumber sets the magic number the image is written with, which
+	// must be one of the two of its format.
+	SetMagicNumber(magicNumber string)
+	// MaxValue returns the max value of a sample, 1 for PBM images.
+	MaxValue() uint16
+	// Invert inverts the colors of the image.
+	Invert()
+	// Flip flips the image horizontally.
+	Flip()
+	// Flop flops the image vertically.
+	Flop()
+	// Rotate90CW rotates the image 90° clockwise.
+	Rotate90CW()
+	// Rotate90CCW rotates the image 90° counterclockwise.
+	Rotate90CCW()
+	// Rotate180 rotates the image 180°.
+	Rotate180()
+	// Transpose mirrors the image along its top-left to bottom-right diagonal.
+	Transpose()
+	// Transverse mirrors the image along its top-right to bottom-left diagonal.
+	Transverse()
+	// Encode writes the image to w.
+	Encode(w io.Writer, opts *EncodeOptions) error
+	// Save saves the image to a file.
+	Save(filename string) error
+}
+
+// Make sure the image types implement Image.
+var (
+	_ Image = (*PBM)(nil)
+	_ Image = (*PGM)(nil)
+	_ Image = (*PPM)(nil)
+)
+
+// Read reads a PBM, PGM or PPM image from a file, depending on its magic number.
+func Read(filename string) (Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	netpbm, ok := img.(Image)
+	if !ok {
+		return nil, headerError(ErrBadMagic, 0, "P7", "magic number is not P1 to P6")
+	}
+	return netpbm, nil
+}
+
+// Encoding selects which variant of a format an image is written in.
+type Encoding int
+
+const (
+	// EncodingDefault keeps the variant given by the magic number of the image.
+	EncodingDefault Encoding = iota
+	// Plain writes the ASCII variant: P1, P2 or P3.
+	Plain
+	// Raw writes the binary variant: P4, P5 or P6.
+	Raw
+)
+
+// defaultLineWidth is the longest line the specification allows in a plain image.
+const defaultLineWidth = 70
+
+// EncodeOptions controls how an image is written. A nil *EncodeOptions is the
+// same as the zero value.
+type EncodeOptions struct {
+	// Encoding chooses between the plain and the raw variant.
+	Encoding Encoding
+	// LineWidth is the maximum length of a line of samples in a plain image.
+	// Zero means 70.
+	LineWidth int
+	// Comments are written in the header, one "#" line each.
+	Comments []string
+}
+
+// magicNumber returns the magic number to write, plain and raw being the two
+// variants of the format and current the magic number stored in the image.
+func (opts *EncodeOptions) magicNumber(current, plain, raw string) string {
+	encoding := EncodingDefault
+	if opts != nil {
+		encoding = opts.Encoding
+	}
+	switch {
+	case encoding == Plain:
+		return plain
+	case encoding == Raw:
+		return raw
+	case current == raw:
+		return raw
+	}
+	return plain
+}
+
+// lineWidth returns the line width to use for plain images.
+func (opts *EncodeOptions) lineWidth() int {
+	if opts == nil || opts.LineWidth <= 0 {
+		return defaultLineWidth
+	}
+	return opts.LineWidth
+}
+
+// writeHeader writes the magic number, the comments, the dimensions and, when
+// max is not zero, the max value.
+func writeHeader(w *bufio.Writer, magicNumber string, width, height, max int, opts *EncodeOptions) {
+	fmt.Fprintf(w, "%s\n", magicNumber)
+	if opts != nil {
+		for _, comment := range opts.Comments {
+			for _, line := range strings.Split(comment, "\n") {
+				fmt.Fprintf(w, "# %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d %d\n", width, height)
+	if max != 0 {
+		fmt.Fprintf(w, "%d\n", max)
+	}
+}
+
+// plainWriter writes the samples of a plain image separated by spaces,
+// starting a new line whenever the next one would not fit in width.
+type plainWriter struct {
+	w      *bufio.Writer
+	width  int
+	column int
+}
+
+// writeSample writes one sample, preceded by a space or a newline.
+func (pw *plainWriter) writeSample(s string) {
+	if pw.column > 0 {
+		if pw.column+1+len(s) > pw.width {
+			pw.w.WriteByte('\n')
+			pw.column = 0
+		} else {
+			pw.w.WriteByte(' ')
+			pw.column++
+		}
+	}
+	pw.w.WriteString(s)
+	pw.column += len(s)
+}
+
+// endRow ends the current row so that each row of the image starts on a new line.
+func (pw *plainWriter) endRow() {
+	pw.w.WriteByte('\n')
+	pw.column = 0
+}
+
+// sampleSize returns the number of bytes a raw sample takes: 1, or 2 in big
+// endian order when the max value is above 255.
+func sampleSize(max int) int {
+	if max > 255 {
+		return 2
+	}
+	return 1
+}
+
+// getSample returns the i-th sample of a raw row whose samples are size bytes long.
+func getSample(row []byte, i, size int) uint16 {
+	if size == 1 {
+		return uint16(row[i])
+	}
+	return binary.BigEndian.Uint16(row[i*2:])
+}
+
+// readSamples reads a raw raster of count samples, with samples samples per
+// pixel and width pixels per row, checking that none is above max. The pixels
+// grow as the raster is read, see readRaster.
+func readSamples(t *tokenizer, width, samples, count, max int) ([]uint16, error) {
+	size := sampleSize(max)
+	position := func(i int) (int, int) {
+		i /= size * samples
+		return i % width, i / width
+	}
+	pix := make([]uint16, 0, min(count, rasterChunk))
+	err := t.readRaster(count*size, size, position, func(chunk []byte, start int) error {
+		offset := t.offset - int64(len(chunk))
+		for i := 0; i < len(chunk)/size; i++ {
+			value := getSample(chunk, i, size)
+			if int(value) > max {
+				x, y := position(start + i*size)
+				return checkSample(int(value), max, offset+int64(i*size), x, y)
+			}
+			pix = append(pix, value)
+		}
+		return nil
+	})
+	return pix, err
+}
+
+// readPlainSamples is like readSamples for a plain raster, made of decimal
+// numbers separated by whitespace.
+func readPlainSamples(t *tokenizer, width, samples, count, max int) ([]uint16, error) {
+	pix := make([]uint16, 0, min(count, rasterChunk))
+	for i := 0; i < count; i++ {
+		x, y := i/samples%width, i/samples/width
+		value, offset, err := t.uint("sample", ErrBadSample)
+		if err != nil {
+			return nil, t.rasterError(err, x, y)
+		}
+		if err := checkSample(value, max, offset, x, y); err != nil {
+			return nil, err
+		}
+		pix = append(pix, uint16(value))
+	}
+	return pix, nil
+}
+
+// appendSample appends a raw sample of size bytes to row.
+func appendSample(row []byte, value uint16, size int) []byte {
+	if size == 1 {
+		return append(row, byte(value))
+	}
+	return binary.BigEndian.AppendUint16(row, value)
+}
@@ -0,0 +1,144 @@
+package Netpbm2
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// The size of the images used by the benchmarks.
+const benchWidth, benchHeight = 1024, 768
+
+// benchPGM returns a gradient PGM of the benchmark size.
+func benchPGM() *PGM {
+	pgm := NewPGM(benchWidth, benchHeight, 255)
+	for y := 0; y < benchHeight; y++ {
+		for x := 0; x < benchWidth; x++ {
+			pgm.SetGray(x, y, uint16((x+y)%256))
+		}
+	}
+	return pgm
+}
+
+// benchPPM returns a gradient PPM of the benchmark size.
+func benchPPM() *PPM {
+	ppm := NewPPM(benchWidth, benchHeight, 255)
+	for y := 0; y < benchHeight; y++ {
+		for x := 0; x < benchWidth; x++ {
+			ppm.SetPixel(x, y, Pixel{uint16(x % 256), uint16(y % 256), uint16((x + y) % 256)})
+		}
+	}
+	return ppm
+}
+
+// encoded returns img written with the given encoding.
+func encoded(b *testing.B, img Image, encoding Encoding) []byte {
+	var buf bytes.Buffer
+	if err := img.Encode(&buf, &EncodeOptions{Encoding: encoding}); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func benchmarkDecodePGM(b *testing.B, encoding Encoding) {
+	data := encoded(b, benchPGM(), encoding)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodePGM(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePGMRaw(b *testing.B)   { benchmarkDecodePGM(b, Raw) }
+func BenchmarkDecodePGMPlain(b *testing.B) { benchmarkDecodePGM(b, Plain) }
+
+func benchmarkDecodePPM(b *testing.B, encoding Encoding) {
+	data := encoded(b, benchPPM(), encoding)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodePPM(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePPMRaw(b *testing.B)   { benchmarkDecodePPM(b, Raw) }
+func BenchmarkDecodePPMPlain(b *testing.B) { benchmarkDecodePPM(b, Plain) }
+
+func BenchmarkPGMInvert(b *testing.B) {
+	pgm := benchPGM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pgm.Invert()
+	}
+}
+
+func BenchmarkPGMFlip(b *testing.B) {
+	pgm := benchPGM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pgm.Flip()
+	}
+}
+
+func BenchmarkPGMRotate90CW(b *testing.B) {
+	pgm := benchPGM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pgm.Rotate90CW()
+	}
+}
+
+func BenchmarkPPMInvert(b *testing.B) {
+	ppm := benchPPM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ppm.Invert()
+	}
+}
+
+func BenchmarkPPMFlip(b *testing.B) {
+	ppm := benchPPM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ppm.Flip()
+	}
+}
+
+func BenchmarkPPMRotate90CW(b *testing.B) {
+	ppm := benchPPM()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ppm.Rotate90CW()
+	}
+}
+
+// errWrite is the error of failingWriter.
+var errWrite = errors.New("broken pipe")
+
+// failingWriter is an io.Writer whose writes all fail.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWrite
+}
+
+func TestEncodeWriteError(t *testing.T) {
+	images := map[string]Image{"PBM": NewPBM(3, 2), "PGM": NewPGM(3, 2, 255), "PPM": NewPPM(3, 2, 255)}
+	for name, img := range images {
+		for _, encoding := range []Encoding{Plain, Raw} {
+			if err := img.Encode(failingWriter{}, &EncodeOptions{Encoding: encoding}); !errors.Is(err, errWrite) {
+				t.Errorf("%s: got error %v, want one wrapping %v", name, err, errWrite)
+			}
+		}
+		if err := NewStreamWriter(failingWriter{}, nil).WriteImage(img); !errors.Is(err, errWrite) {
+			t.Errorf("%s stream: got error %v, want one wrapping %v", name, err, errWrite)
+		}
+	}
+	if err := NewPGM(3, 2, 255).ToPAM().Encode(failingWriter{}, nil); !errors.Is(err, errWrite) {
+		t.Errorf("PAM: got error %v, want one wrapping %v", err, errWrite)
+	}
+}
@@ -0,0 +1,48 @@
+package Netpbm2
+
+// Orientation is the EXIF orientation of an image: where its first row and
+// its first column are meant to be displayed.
+type Orientation int
+
+// The eight orientations, numbered as in the EXIF Orientation tag.
+const (
+	// OrientationTopLeft is an image that is displayed as it is stored.
+	OrientationTopLeft Orientation = iota + 1
+	// OrientationTopRight is an image that is stored flipped horizontally.
+	OrientationTopRight
+	// OrientationBottomRight is an image that is stored rotated 180°.
+	OrientationBottomRight
+	// OrientationBottomLeft is an image that is stored flopped vertically.
+	OrientationBottomLeft
+	// OrientationLeftTop is an image that is stored transposed.
+	OrientationLeftTop
+	// OrientationRightTop is an image that is stored rotated 90°
+	// counterclockwise, so that it must be rotated 90° clockwise.
+	OrientationRightTop
+	// OrientationRightBottom is an image that is stored transversed.
+	OrientationRightBottom
+	// OrientationLeftBottom is an image that is stored rotated 90° clockwise,
+	// so that it must be rotated 90° counterclockwise.
+	OrientationLeftBottom
+)
+
+// Normalize transforms img, stored with the given orientation, so that it is
+// displayed as it is stored. Unknown orientations leave img as it is.
+func Normalize(img Image, orientation Orientation) {
+	switch orientation {
+	case OrientationTopRight:
+		img.Flip()
+	case OrientationBottomRight:
+		img.Rotate180()
+	case OrientationBottomLeft:
+		img.Flop()
+	case OrientationLeftTop:
+		img.Transpose()
+	case OrientationRightTop:
+		img.Rotate90CW()
+	case OrientationRightBottom:
+		img.Transverse()
+	case OrientationLeftBottom:
+		img.Rotate90CCW()
+	}
+}
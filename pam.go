@@ -0,0 +1,384 @@
+package Netpbm2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Tuple types defined by the PAM specification.
+const (
+	TupleBlackAndWhite      = "BLACKANDWHITE"
+	TupleGrayscale          = "GRAYSCALE"
+	TupleRGB                = "RGB"
+	TupleBlackAndWhiteAlpha = "BLACKANDWHITE_ALPHA"
+	TupleGrayscaleAlpha     = "GRAYSCALE_ALPHA"
+	TupleRGBAlpha           = "RGB_ALPHA"
+)
+
+// PAM represents a PAM image, each pixel being a tuple of depth samples.
+type PAM struct {
+	// pix holds the rows one after the other, with the tuple of the pixel
+	// (x, y) starting at pix[y*stride+x*depth]
+	pix           []uint16
+	stride        int
+	width, height int
+	depth         int
+	max           uint16
+	tupleType     string
+}
+
+// NewPAM returns a PAM image of the given size filled with zeros.
+func NewPAM(width, height, depth int, max uint16, tupleType string) *PAM {
+	return &PAM{pix: make([]uint16, width*height*depth), stride: width * depth, width: width, height: height, depth: depth, max: max, tupleType: tupleType}
+}
+
+// ReadPAM reads a PAM image from a file and returns a struct that represents the image.
+func ReadPAM(filename string) (*PAM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodePAM(file)
+}
+
+// readPAMHeader reads the header of a PAM image, from the magic number to the
+// ENDHDR line included.
+func readPAMHeader(t *tokenizer) (*PAM, error) {
+	magicNumber, offset, err := t.magicNumber()
+	if err != nil {
+		return nil, err
+	}
+	if magicNumber != "P7" {
+		return nil, headerError(ErrBadMagic, offset, magicNumber, "magic number is not P7")
+	}
+	// Skip the end of the magic number line
+	if _, _, err := t.line(); err != nil {
+		return nil, err
+	}
+	pam := &PAM{}
+	max := 0
+	var tupleTypes []string
+	for {
+		line, offset, err := t.line()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		// Skip empty lines and comments
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if fields[0] == "TUPLTYPE" {
+			// The tuple type is the rest of the line, and repeated lines are joined by a space
+			tupleTypes = append(tupleTypes, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "TUPLTYPE")))
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, headerError(ErrBadHeader, offset, line, "invalid header line")
+		}
+		value, err := strconv.Atoi(fields[1])
+		if err != nil || value < 1 || fields[1][0] == '+' {
+			return nil, headerError(ErrBadHeader, offset, line, "invalid "+fields[0])
+		}
+		switch fields[0] {
+		case "WIDTH":
+			pam.width = value
+		case "HEIGHT":
+			pam.height = value
+		case "DEPTH":
+			pam.depth = value
+		case "MAXVAL":
+			if value > 65535 {
+				return nil, headerError(ErrBadHeader, offset, line, "max value must go from 1 to 65535")
+			}
+			max = value
+		default:
+			return nil, headerError(ErrBadHeader, offset, line, "invalid header line")
+		}
+	}
+	if pam.width == 0 || pam.height == 0 || pam.depth == 0 || max == 0 {
+		return nil, headerError(ErrBadHeader, t.offset, "ENDHDR", "header is missing WIDTH, HEIGHT, DEPTH or MAXVAL")
+	}
+	pam.max = uint16(max)
+	pam.tupleType = strings.Join(tupleTypes, " ")
+	return pam, nil
+}
+
+// DecodePAM reads a P7 image from r. Only the bytes of the image are consumed
+// when r is a *bufio.Reader.
+func DecodePAM(r io.Reader) (*PAM, error) {
+	return DecodePAMWithOptions(r, DefaultDecodeOptions())
+}
+
+// DecodePAMWithOptions is like DecodePAM but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodePAMWithOptions(r io.Reader, opts *DecodeOptions) (*PAM, error) {
+	t := newTokenizer(r)
+	opts.start(t)
+	h, err := readPAMHeader(t)
+	if err != nil {
+		return nil, err
+	}
+	rasterBytes := int64(h.width) * int64(h.height) * int64(h.depth*sampleSize(int(h.max)))
+	if err := opts.check(t, h.width, h.height, h.depth, 16, rasterBytes); err != nil {
+		return nil, err
+	}
+	pam := &PAM{stride: h.width * h.depth, width: h.width, height: h.height, depth: h.depth, max: h.max, tupleType: h.tupleType}
+	pam.pix, err = readSamples(t, h.width, h.depth, h.width*h.height*h.depth, int(h.max))
+	if err != nil {
+		return nil, err
+	}
+	return pam, nil
+}
+
+// Save saves the PAM image to a file.
+func (pam *PAM) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := pam.Encode(file, nil); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Encode writes the PAM image to w. PAM only has a raw variant, so only the
+// comments of opts are used.
+func (pam *PAM) Encode(w io.Writer, opts *EncodeOptions) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprint(writer, "P7\n")
+	if opts != nil {
+		for _, comment := range opts.Comments {
+			for _, line := range strings.Split(comment, "\n") {
+				fmt.Fprintf(writer, "# %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(writer, "WIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\n", pam.width, pam.height, pam.depth, pam.max)
+	if pam.tupleType != "" {
+		fmt.Fprintf(writer, "TUPLTYPE %s\n", pam.tupleType)
+	}
+	fmt.Fprint(writer, "ENDHDR\n")
+	size := sampleSize(int(pam.max))
+	buf := make([]byte, 0, pam.width*pam.depth*size)
+	for y := 0; y < pam.height; y++ {
+		buf = buf[:0]
+		for _, sample := range pam.row(y) {
+			buf = appendSample(buf, sample, size)
+		}
+		writer.Write(buf)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
+	return nil
+}
+
+// Size returns the width and height of the PAM image.
+func (pam *PAM) Size() (int, int) {
+	return pam.width, pam.height
+}
+
+// Depth returns the number of samples of each pixel.
+func (pam *PAM) Depth() int {
+	return pam.depth
+}
+
+// MaxValue returns the max value of a sample.
+func (pam *PAM) MaxValue() uint16 {
+	return pam.max
+}
+
+// TupleType returns the tuple type of the PAM image, empty if the header had none.
+func (pam *PAM) TupleType() string {
+	return pam.tupleType
+}
+
+// TupleAt returns the samples of the pixel at (x, y). The returned slice shares
+// the memory of the image.
+func (pam *PAM) TupleAt(x, y int) []uint16 {
+	i := y*pam.stride + x*pam.depth
+	return pam.pix[i : i+pam.depth]
+}
+
+// SetTuple sets the samples of the pixel at (x, y).
+func (pam *PAM) SetTuple(x, y int, tuple []uint16) {
+	copy(pam.TupleAt(x, y), tuple)
+}
+
+// Pix returns the samples of the image, depth for each pixel and Stride per
+// row. The returned slice shares the memory of the image.
+func (pam *PAM) Pix() []uint16 {
+	return pam.pix
+}
+
+// Stride returns the number of samples between the starts of two rows in Pix.
+func (pam *PAM) Stride() int {
+	return pam.stride
+}
+
+// row returns the samples of row y.
+func (pam *PAM) row(y int) []uint16 {
+	return pam.pix[y*pam.stride : y*pam.stride+pam.width*pam.depth]
+}
+
+// hasAlpha reports whether the last sample of each tuple is an opacity.
+func (pam *PAM) hasAlpha() bool {
+	return strings.HasSuffix(pam.tupleType, "_ALPHA") && pam.depth > 1
+}
+
+// colorDepth returns the number of samples of each tuple that are not opacity.
+func (pam *PAM) colorDepth() int {
+	if pam.hasAlpha() {
+		return pam.depth - 1
+	}
+	return pam.depth
+}
+
+// isBlackAndWhite reports whether 0 is black and any other value white.
+func (pam *PAM) isBlackAndWhite() bool {
+	return strings.HasPrefix(pam.tupleType, TupleBlackAndWhite)
+}
+
+// gray returns the gray value of the pixel at (x, y), averaging the red, green
+// and blue of color tuples.
+func (pam *PAM) gray(x, y int) int {
+	tuple := pam.TupleAt(x, y)
+	if pam.colorDepth() >= 3 {
+		return (int(tuple[0]) + int(tuple[1]) + int(tuple[2])) / 3
+	}
+	return int(tuple[0])
+}
+
+// ToPAM converts the PBM image to a BLACKANDWHITE PAM image. Beware that in a
+// PAM image 0 is black and 1 is white, unlike in PBM.
+func (pbm *PBM) ToPAM() *PAM {
+	pam := NewPAM(pbm.width, pbm.height, 1, 1, TupleBlackAndWhite)
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if !pbm.BitAt(x, y) {
+				pam.pix[y*pam.stride+x] = 1
+			}
+		}
+	}
+	return pam
+}
+
+// ToPAM converts the PGM image to a GRAYSCALE PAM image with the same max value.
+func (pgm *PGM) ToPAM() *PAM {
+	pam := NewPAM(pgm.width, pgm.height, 1, pgm.max, TupleGrayscale)
+	for y := 0; y < pgm.height; y++ {
+		copy(pam.row(y), pgm.row(y))
+	}
+	return pam
+}
+
+// ToPAM converts the PPM image to an RGB PAM image with the same max value.
+func (ppm *PPM) ToPAM() *PAM {
+	pam := NewPAM(ppm.width, ppm.height, 3, ppm.max, TupleRGB)
+	for y := 0; y < ppm.height; y++ {
+		copy(pam.row(y), ppm.row(y))
+	}
+	return pam
+}
+
+// ToPBM converts the PAM image to PBM, dropping the opacity. BLACKANDWHITE
+// images are converted as they are, the others are thresholded at half the
+// max value.
+func (pam *PAM) ToPBM() *PBM {
+	pbm := NewPBM(pam.width, pam.height)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			if pam.isBlackAndWhite() {
+				pbm.SetBit(x, y, pam.TupleAt(x, y)[0] == 0)
+			} else {
+				pbm.SetBit(x, y, pam.gray(x, y) < int(pam.max)/2)
+			}
+		}
+	}
+	return pbm
+}
+
+// ToPGM converts the PAM image to PGM with the same max value, dropping the
+// opacity. Color tuples are averaged.
+func (pam *PAM) ToPGM() *PGM {
+	pgm := NewPGM(pam.width, pam.height, pam.max)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			pgm.SetGray(x, y, uint16(pam.gray(x, y)))
+		}
+	}
+	return pgm
+}
+
+// ToPPM converts the PAM image to PPM with the same max value, dropping the
+// opacity. Gray tuples become gray pixels.
+func (pam *PAM) ToPPM() *PPM {
+	ppm := NewPPM(pam.width, pam.height, pam.max)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			tuple := pam.TupleAt(x, y)
+			if pam.colorDepth() >= 3 {
+				ppm.SetPixel(x, y, Pixel{tuple[0], tuple[1], tuple[2]})
+			} else {
+				ppm.SetPixel(x, y, Pixel{tuple[0], tuple[0], tuple[0]})
+			}
+		}
+	}
+	return ppm
+}
+
+// Alpha returns the opacity of the PAM image as a PGM image with the same max
+// value, or nil when the tuple type has no opacity.
+func (pam *PAM) Alpha() *PGM {
+	if !pam.hasAlpha() {
+		return nil
+	}
+	alpha := NewPGM(pam.width, pam.height, pam.max)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			alpha.SetGray(x, y, pam.TupleAt(x, y)[pam.depth-1])
+		}
+	}
+	return alpha
+}
+
+// SetAlpha sets the opacity of the PAM image from alpha, scaled to the max
+// value of the image. A BLACKANDWHITE, GRAYSCALE or RGB image gets an opacity
+// sample and the matching _ALPHA tuple type.
+func (pam *PAM) SetAlpha(alpha *PGM) error {
+	if alpha.width != pam.width || alpha.height != pam.height {
+		return fmt.Errorf("alpha is %dx%d, image is %dx%d", alpha.width, alpha.height, pam.width, pam.height)
+	}
+	if !pam.hasAlpha() {
+		switch pam.tupleType {
+		case TupleBlackAndWhite, TupleGrayscale, TupleRGB:
+		default:
+			return fmt.Errorf("cannot add opacity to tuple type %q", pam.tupleType)
+		}
+		// Make room for one more sample at the end of each tuple
+		withAlpha := NewPAM(pam.width, pam.height, pam.depth+1, pam.max, pam.tupleType+"_ALPHA")
+		for y := 0; y < pam.height; y++ {
+			for x := 0; x < pam.width; x++ {
+				withAlpha.SetTuple(x, y, pam.TupleAt(x, y))
+			}
+		}
+		*pam = *withAlpha
+	}
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			pam.TupleAt(x, y)[pam.depth-1] = rescale(alpha.GrayAt(x, y), alpha.max, pam.max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package Netpbm2
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestToPBMThreshold(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, max := range []uint16{1, 2, 255, 1000} {
+		pgm := NewPGM(9, 7, max)
+		ppm := NewPPM(9, 7, max)
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 9; x++ {
+				v := uint16(rng.Intn(int(max) + 1))
+				pgm.SetGray(x, y, v)
+				ppm.SetPixel(x, y, Pixel{v, v, v})
+			}
+		}
+		want := pgm.ToPBM()
+		for name, got := range map[string]*PBM{"PAM": pgm.ToPAM().ToPBM(), "PPM": ppm.ToPBM(), "PPM PAM": ppm.ToPAM().ToPBM()} {
+			for y := 0; y < 7; y++ {
+				for x := 0; x < 9; x++ {
+					if got.BitAt(x, y) != want.BitAt(x, y) {
+						t.Fatalf("max %d: %s gives %v for gray %d, PGM gives %v", max, name, got.BitAt(x, y), pgm.GrayAt(x, y), want.BitAt(x, y))
+					}
+				}
+			}
+		}
+		if v := pgm.GrayAt(0, 0); want.BitAt(0, 0) != (v < max/2) {
+			t.Errorf("max %d: gray %d gives %v", max, v, want.BitAt(0, 0))
+		}
+	}
+}
+
+func TestSetAlphaRounds(t *testing.T) {
+	pam := NewPGM(2, 1, 255).ToPAM()
+	alpha := NewPGM(2, 1, 65535)
+	alpha.SetGray(0, 0, 32839)
+	alpha.SetGray(1, 0, 2699)
+	if err := pam.SetAlpha(alpha); err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint16{128, 11} {
+		if got := pam.TupleAt(x, 0)[1]; got != want {
+			t.Errorf("opacity of pixel %d is %d, want %d", x, got, want)
+		}
+	}
+}
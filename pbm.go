@@ -3,16 +3,31 @@ package Netpbm2
 import (
 	"bufio"
 	"fmt"
+	"image"
+	"io"
 	"os"
-	"strings"
 )
 
+// PBM represents a PBM image. The pixels are packed 8 per byte as in a P4
+// raster: each row starts on a new byte, the leftmost pixel is the most
+// significant bit and a set bit is black.
 type PBM struct {
-	data          [][]bool
+	pix    []byte
+	stride int
+	// bitOffset is the bit of pix[0] holding the pixel (0, 0). It is only
+	// set in a view returned by SubImage whose left edge is inside a byte.
+	bitOffset     int
 	width, height int
 	magicNumber   string
 }
 
+// NewPBM returns a white P1 image of the given size.
+func NewPBM(width, height int) *PBM {
+	stride := (width + 7) / 8
+	return &PBM{pix: make([]byte, stride*height), stride: stride, width: width, height: height, magicNumber: "P1"}
+}
+
+// ReadPBM reads a PBM image from a file and returns a struct that represents the image.
 func ReadPBM(filename string) (*PBM, error) {
 	//Open the file
 	file, err := os.Open(filename)
@@ -21,156 +36,311 @@ func ReadPBM(filename string) (*PBM, error) {
 		return nil, err
 	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	//Create PBM variable
-	pbm := &PBM{}
-	//Lines of the image
-	line := 0
-	//Loop each line
-	for scanner.Scan() {
-		text := scanner.Text()
-		//Ignore comments ect....
-		if text == "" || strings.HasPrefix(text, "#") {
-			continue
-		}
-		if pbm.magicNumber == "" {
-			//Make sure pbm.magicNumber contains text
-			pbm.magicNumber = strings.TrimSpace(text)
-		} else if pbm.width == 0 {
-			//Get the width and height of the pbm
-			fmt.Sscanf(text, "%d %d", &pbm.width, &pbm.height)
-			//Initialize the pbm.data matrix variable by creating the correct amount and size of arrays in an array
-			pbm.data = make([][]bool, pbm.height)
-			for i := range pbm.data {
-				pbm.data[i] = make([]bool, pbm.width)
-			}
-		} else {
-			if pbm.magicNumber == "P1" {
-				//Each word in the text string is printed on a new line
-				test := strings.Fields(text)
-				//Loop through the string[]
-				for i := 0; i < pbm.width; i++ {
-					//If the given string == "1", then it is stored as true or else as false
-					pbm.data[line][i] = (test[i] == "1")
-				}
-				line++
-			} else if pbm.magicNumber == "P4" {
-				//Calculate the expected number of bytes per row
-				expectedBytesPerRow := (pbm.width + 7) / 8
-				totalExpectedBytes := expectedBytesPerRow * pbm.height
-				allPixelData := make([]byte, totalExpectedBytes)
-				//Reads the file content
-				fileContent, err := os.ReadFile(filename)
+	return DecodePBM(file)
+}
+
+// DecodePBM reads a P1 or P4 image from r. Only the bytes of the image are
+// consumed when r is a *bufio.Reader.
+func DecodePBM(r io.Reader) (*PBM, error) {
+	return DecodePBMWithOptions(r, DefaultDecodeOptions())
+}
+
+// DecodePBMWithOptions is like DecodePBM but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodePBMWithOptions(r io.Reader, opts *DecodeOptions) (*PBM, error) {
+	t := newTokenizer(r)
+	opts.start(t)
+	h, err := t.header()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.expect("P1", "P4"); err != nil {
+		return nil, err
+	}
+	if err := opts.check(t, h.width, h.height, h.samples(), 1, h.rasterBytes()); err != nil {
+		return nil, err
+	}
+	//Create PBM variable, whose pixels grow as the raster is read
+	pbm := &PBM{stride: (h.width + 7) / 8, width: h.width, height: h.height, magicNumber: h.magicNumber}
+	pbm.pix = make([]byte, 0, min(pbm.stride*pbm.height, rasterChunk))
+	if pbm.magicNumber == "P1" {
+		for y := 0; y < pbm.height; y++ {
+			var b byte
+			for x := 0; x < pbm.width; x++ {
+				//"1" is stored as true and "0" as false
+				bit, err := t.bit()
 				if err != nil {
-					return nil, fmt.Errorf("couldn't read file: %v", err)
+					return nil, t.rasterError(err, x, y)
 				}
-				//Extracts the necessary pixel data
-				copy(allPixelData, fileContent[len(fileContent)-totalExpectedBytes:])
-				//Process the data to fill the pixel array of pbm.data
-				byteIndex := 0
-				for y := 0; y < pbm.height; y++ {
-					for x := 0; x < pbm.width; x++ {
-						if x%8 == 0 && x != 0 {
-							byteIndex++
-						}
-						pbm.data[y][x] = (allPixelData[byteIndex]>>(7-(x%8)))&1 != 0
-					}
-					byteIndex++
+				if bit {
+					b |= 0x80 >> (x % 8)
+				}
+				if x%8 == 7 || x == pbm.width-1 {
+					pbm.pix = append(pbm.pix, b)
+					b = 0
 				}
-				break
 			}
 		}
+		return pbm, nil
+	}
+	//The raster is stored exactly like pbm.pix
+	position := func(i int) (int, int) {
+		return i % pbm.stride * 8, i / pbm.stride
+	}
+	err = t.readRaster(pbm.stride*pbm.height, 1, position, func(chunk []byte, _ int) error {
+		pbm.pix = append(pbm.pix, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	//Clear the padding bits at the end of each row, which may hold anything
+	if pbm.width%8 != 0 {
+		mask := byte(0xff) << (8 - pbm.width%8)
+		for y := 0; y < pbm.height; y++ {
+			pbm.pix[y*pbm.stride+pbm.stride-1] &= mask
+		}
 	}
 	return pbm, nil
 }
 
+// Size returns the width and height of the PBM image.
 func (pbm *PBM) Size() (int, int) {
 	//Return size
 	return pbm.width, pbm.height
 }
 
-func (pbm *PBM) At(x, y int) bool {
+// MagicNumber returns the magic number of the PBM image, P1 or P4.
+func (pbm *PBM) MagicNumber() string {
+	return pbm.magicNumber
+}
+
+// SetMagicNumber sets the magic number of the PBM image, P1 or P4.
+func (pbm *PBM) SetMagicNumber(magicNumber string) {
+	pbm.magicNumber = magicNumber
+}
+
+// MaxValue returns 1, a PBM pixel being either 0 or 1.
+func (pbm *PBM) MaxValue() uint16 {
+	return 1
+}
+
+// Pix returns the packed pixels, Stride bytes per row, the first pixel of each
+// row being at bit BitOffset of its first byte. The returned slice shares the
+// memory of the image.
+func (pbm *PBM) Pix() []byte {
+	return pbm.pix
+}
+
+// Stride returns the number of bytes between the starts of two rows in Pix.
+func (pbm *PBM) Stride() int {
+	return pbm.stride
+}
+
+// BitOffset returns the bit of the first byte of a row in Pix that holds the
+// first pixel of the row, 0 being the most significant one. It is 0 except for
+// a view whose left edge is not a multiple of 8.
+func (pbm *PBM) BitOffset() int {
+	return pbm.bitOffset
+}
+
+// SubImage returns the part of the image inside r, clipped to the bounds of the
+// image, as a PBM image whose top-left pixel is (0, 0). The view shares its
+// pixels with pbm, so drawing on it or inverting it changes pbm too, until a
+// method that builds new pixels, such as Rotate90CW, Crop or Erode, gives the
+// view pixels of its own. Pix of a view whose left edge is not a multiple of 8
+// starts in the middle of a byte, at BitOffset.
+func (pbm *PBM) SubImage(r image.Rectangle) *PBM {
+	r = r.Intersect(pbm.Bounds())
+	if r.Empty() {
+		return &PBM{magicNumber: pbm.magicNumber}
+	}
+	bit := pbm.bitOffset + r.Min.X
+	return &PBM{
+		pix:         pbm.pix[r.Min.Y*pbm.stride+bit/8:],
+		stride:      pbm.stride,
+		bitOffset:   bit % 8,
+		width:       r.Dx(),
+		height:      r.Dy(),
+		magicNumber: pbm.magicNumber,
+	}
+}
+
+// BitAt returns the value of the pixel at (x, y), true being black.
+func (pbm *PBM) BitAt(x, y int) bool {
 	//Return value a pixel
-	return pbm.data[y][x]
+	x += pbm.bitOffset
+	return pbm.pix[y*pbm.stride+x/8]&(0x80>>(x%8)) != 0
 }
 
-func (pbm *PBM) Set(x, y int, value bool) {
+// SetBit sets the value of the pixel at (x, y), true being black.
+func (pbm *PBM) SetBit(x, y int, value bool) {
 	//Define a new value pixel
-	pbm.data[y][x] = value
+	x += pbm.bitOffset
+	if value {
+		pbm.pix[y*pbm.stride+x/8] |= 0x80 >> (x % 8)
+	} else {
+		pbm.pix[y*pbm.stride+x/8] &^= 0x80 >> (x % 8)
+	}
 }
 
+// rowMask returns the mask of the bits of pix[y*stride+i] that belong to the
+// image, for the bytes i from 0 to last.
+func (pbm *PBM) rowMask(i, last int) byte {
+	mask := byte(0xff)
+	if i == 0 {
+		mask >>= pbm.bitOffset
+	}
+	if i == last {
+		mask &= 0xff << (7 - (pbm.bitOffset+pbm.width-1)%8)
+	}
+	return mask
+}
+
+// Save saves the PBM image to a file in the same format as the original image.
 func (pbm *PBM) Save(filename string) error {
 	// Create a new file or truncate an existing file
 	file, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("error creating file: %v", err)
 	}
-	defer file.Close()
-
-	// Write the magic number to the file
-	_, err = fmt.Fprintf(file, "%s\n", pbm.magicNumber)
-	if err != nil {
-		return fmt.Errorf("error writing magic number: %v", err)
-	}
-
-	// Write the dimensions (width and height) to the file
-	_, err = fmt.Fprintf(file, "%d %d\n", pbm.width, pbm.height)
-	if err != nil {
-		return fmt.Errorf("error writing dimensions: %v", err)
+	if err := pbm.Encode(file, nil); err != nil {
+		file.Close()
+		return err
 	}
+	return file.Close()
+}
 
-	// Iterate through each row of pixel data
-	for _, row := range pbm.data {
-		// Iterate through each pixel in the row
-		for _, pixel := range row {
-			// Write pixel data based on the PBM magic number
-			if pbm.magicNumber == "P1" {
-				if pixel {
-					_, err = fmt.Fprint(file, "1 ")
-				} else {
-					_, err = fmt.Fprint(file, "0 ")
-				}
-			} else if pbm.magicNumber == "P4" {
-				if pixel {
-					_, err = fmt.Fprint(file, "1")
+// Encode writes the PBM image to w as P1 or P4, as chosen by opts.
+func (pbm *PBM) Encode(w io.Writer, opts *EncodeOptions) error {
+	writer := bufio.NewWriter(w)
+	magicNumber := opts.magicNumber(pbm.magicNumber, "P1", "P4")
+	writeHeader(writer, magicNumber, pbm.width, pbm.height, 0, opts)
+	if magicNumber == "P1" {
+		pw := &plainWriter{w: writer, width: opts.lineWidth()}
+		for y := 0; y < pbm.height; y++ {
+			for x := 0; x < pbm.width; x++ {
+				if pbm.BitAt(x, y) {
+					pw.writeSample("1")
 				} else {
-					_, err = fmt.Fprint(file, "0")
+					pw.writeSample("0")
 				}
 			}
-			// Check for errors during pixel data writing
-			if err != nil {
-				return fmt.Errorf("error writing data: %v", err)
-			}
+			pw.endRow()
 		}
-		// Add a newline if the magic number is "P1"
-		if pbm.magicNumber == "P1" {
-			_, err = fmt.Fprintln(file)
-			if err != nil {
-				return fmt.Errorf("error writing data: %v", err)
+	} else {
+		// The rows are already packed the way P4 wants them, unless pbm is a
+		// view that does not start or end on a byte boundary
+		size := (pbm.width + 7) / 8
+		row := make([]byte, size)
+		// An image without columns has no raster bytes at all
+		for y := 0; size > 0 && y < pbm.height; y++ {
+			if pbm.bitOffset == 0 {
+				copy(row, pbm.pix[y*pbm.stride:y*pbm.stride+size])
+				row[size-1] &= pbm.rowMask(size-1, size-1)
+			} else {
+				for i := range row {
+					row[i] = 0
+				}
+				for x := 0; x < pbm.width; x++ {
+					if pbm.BitAt(x, y) {
+						row[x/8] |= 0x80 >> (x % 8)
+					}
+				}
 			}
+			writer.Write(row)
 		}
 	}
-
-	// Print a success message to the console
-	fmt.Printf("The image is saved in %s\n", filename)
-
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing data: %w", err)
+	}
 	return nil
 }
 
+// Flip flips the PBM image horizontally.
 func (pbm *PBM) Flip() {
 	for i := 0; i < pbm.height; i++ {
 		for j := 0; j < pbm.width/2; j++ {
-			//FExchange between two elements of a matrix row
-			pbm.data[i][j], pbm.data[i][pbm.width-j-1] = pbm.data[i][pbm.width-j-1], pbm.data[i][j]
+			//Exchange between two elements of a row
+			left, right := pbm.BitAt(j, i), pbm.BitAt(pbm.width-j-1, i)
+			pbm.SetBit(j, i, right)
+			pbm.SetBit(pbm.width-j-1, i, left)
+		}
+	}
+}
+
+// Flop flops the PBM image vertically.
+func (pbm *PBM) Flop() {
+	for i := 0; i < pbm.height/2; i++ {
+		for j := 0; j < pbm.width; j++ {
+			//Exchange between two elements of a column
+			top, bottom := pbm.BitAt(j, i), pbm.BitAt(j, pbm.height-i-1)
+			pbm.SetBit(j, i, bottom)
+			pbm.SetBit(j, pbm.height-i-1, top)
 		}
 	}
 }
 
+// Rotate90CW rotates the PBM image 90° clockwise.
+func (pbm *PBM) Rotate90CW() {
+	pbm.transpose(true, false)
+}
+
+// Rotate90CCW rotates the PBM image 90° counterclockwise.
+func (pbm *PBM) Rotate90CCW() {
+	pbm.transpose(false, true)
+}
+
+// Rotate180 rotates the PBM image 180°.
+func (pbm *PBM) Rotate180() {
+	pbm.Flip()
+	pbm.Flop()
+}
+
+// Transpose mirrors the PBM image along its top-left to bottom-right diagonal.
+func (pbm *PBM) Transpose() {
+	pbm.transpose(false, false)
+}
+
+// Transverse mirrors the PBM image along its top-right to bottom-left diagonal.
+func (pbm *PBM) Transverse() {
+	pbm.transpose(true, true)
+}
+
+// transpose swaps the rows and the columns of the image, then flips it
+// horizontally if flip is true and vertically if flop is true. The pixels are
+// moved into a new buffer, so a view stops sharing them with its parent.
+func (pbm *PBM) transpose(flip, flop bool) {
+	//Create a new image with the width and the height swapped
+	transposed := NewPBM(pbm.height, pbm.width)
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			//Row y becomes column y
+			tx, ty := y, x
+			if flip {
+				tx = transposed.width - 1 - tx
+			}
+			if flop {
+				ty = transposed.height - 1 - ty
+			}
+			transposed.SetBit(tx, ty, pbm.BitAt(x, y))
+		}
+	}
+	transposed.magicNumber = pbm.magicNumber
+	*pbm = *transposed
+}
+
+// Invert inverts the colors of the PBM image.
 func (pbm *PBM) Invert() {
+	//Whole bytes are inverted at once, leaving the bits outside the image as they are
+	if pbm.width == 0 {
+		return
+	}
+	last := (pbm.bitOffset + pbm.width - 1) / 8
 	for i := 0; i < pbm.height; i++ {
-		for j := 0; j < pbm.width; j++ {
-			// Invert the color by flipping the boolean value
-			pbm.data[i][j] = !pbm.data[i][j]
+		row := pbm.pix[i*pbm.stride:]
+		for j := 0; j <= last; j++ {
+			row[j] ^= pbm.rowMask(j, last)
 		}
 	}
-}
\ No newline at end of file
+}
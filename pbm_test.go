@@ -0,0 +1,105 @@
+package Netpbm2
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// randomPBM returns a PBM image of the given size with random pixels.
+func randomPBM(rng *rand.Rand, width, height int) *PBM {
+	pbm := NewPBM(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pbm.SetBit(x, y, rng.Intn(2) == 1)
+		}
+	}
+	return pbm
+}
+
+// views returns rectangles of a 40x6 image whose left and right edges fall on
+// and off byte boundaries.
+func views() []image.Rectangle {
+	return []image.Rectangle{
+		image.Rect(0, 0, 40, 6),
+		image.Rect(3, 1, 6, 5),
+		image.Rect(3, 1, 13, 5),
+		image.Rect(8, 0, 16, 6),
+		image.Rect(7, 2, 33, 3),
+		image.Rect(1, 0, 39, 6),
+		image.Rect(39, 0, 40, 6),
+	}
+}
+
+func TestPBMSubImageInvert(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, r := range views() {
+		pbm := randomPBM(rng, 40, 6)
+		want := NewPBM(40, 6)
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 40; x++ {
+				want.SetBit(x, y, pbm.BitAt(x, y) != image.Pt(x, y).In(r))
+			}
+		}
+		view := pbm.SubImage(r)
+		if view.BitOffset() != r.Min.X%8 {
+			t.Errorf("view %v has bit offset %d, want %d", r, view.BitOffset(), r.Min.X%8)
+		}
+		view.Invert()
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 40; x++ {
+				if pbm.BitAt(x, y) != want.BitAt(x, y) {
+					t.Fatalf("inverting view %v: pixel (%d, %d) is %v, want %v", r, x, y, pbm.BitAt(x, y), want.BitAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestPBMSubImageEncode(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	pbm := randomPBM(rng, 40, 6)
+	for _, r := range views() {
+		for _, encoding := range []Encoding{Raw, Plain} {
+			view := pbm.SubImage(r)
+			var buf bytes.Buffer
+			if err := view.Encode(&buf, &EncodeOptions{Encoding: encoding}); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodePBM(&buf)
+			if err != nil {
+				t.Fatalf("view %v: %v", r, err)
+			}
+			if w, h := decoded.Size(); w != r.Dx() || h != r.Dy() {
+				t.Fatalf("view %v decoded as %dx%d", r, w, h)
+			}
+			for y := 0; y < r.Dy(); y++ {
+				for x := 0; x < r.Dx(); x++ {
+					if decoded.BitAt(x, y) != pbm.BitAt(r.Min.X+x, r.Min.Y+y) {
+						t.Fatalf("view %v: pixel (%d, %d) did not round-trip", r, x, y)
+					}
+				}
+			}
+			if encoding == Raw && buf.Len() != 0 {
+				t.Errorf("view %v: %d bytes left after the raster", r, buf.Len())
+			}
+		}
+	}
+}
+
+func TestPBMEncodeNoColumns(t *testing.T) {
+	for _, encoding := range []Encoding{Raw, Plain} {
+		var buf bytes.Buffer
+		if err := NewPBM(0, 3).Encode(&buf, &EncodeOptions{Encoding: encoding}); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePBM(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := decoded.Size(); w != 0 || h != 3 {
+			t.Errorf("decoded as %dx%d, want 0x3", w, h)
+		}
+	}
+}
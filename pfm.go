@@ -0,0 +1,351 @@
+package Netpbm2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// PFM represents a PFM image, whose samples are 32-bit floating point values,
+// usually linear light with 1 as the reference white.
+type PFM struct {
+	// pix holds the rows one after the other from top to bottom, with the
+	// samples of the pixel (x, y) starting at pix[y*stride+x*channels]
+	pix           []float32
+	stride        int
+	width, height int
+	// channels is 3 for a color "PF" image and 1 for a grayscale "Pf" image
+	channels int
+	// scale is the absolute value of the scale factor found in the header
+	scale float32
+	// littleEndian tells the byte order of the samples in the file
+	littleEndian bool
+}
+
+// NewPFM returns a black PFM image of the given size, with 3 channels for a
+// color image or 1 for a grayscale image. It is written in little endian order.
+func NewPFM(width, height, channels int) *PFM {
+	return &PFM{pix: make([]float32, width*height*channels), stride: width * channels, width: width, height: height, channels: channels, scale: 1, littleEndian: true}
+}
+
+// ReadPFM reads a PFM image from a file and returns a struct that represents the image.
+func ReadPFM(filename string) (*PFM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodePFM(file)
+}
+
+// DecodePFM reads a PF or Pf image from r. Only the bytes of the image are
+// consumed when r is a *bufio.Reader.
+func DecodePFM(r io.Reader) (*PFM, error) {
+	return DecodePFMWithOptions(r, DefaultDecodeOptions())
+}
+
+// DecodePFMWithOptions is like DecodePFM but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodePFMWithOptions(r io.Reader, opts *DecodeOptions) (*PFM, error) {
+	t := newTokenizer(r)
+	opts.start(t)
+	magicNumber, offset, err := t.magicNumber()
+	if err != nil {
+		return nil, err
+	}
+	channels := 3
+	switch magicNumber {
+	case "PF":
+	case "Pf":
+		channels = 1
+	default:
+		return nil, headerError(ErrBadMagic, offset, magicNumber, "magic number is not PF or Pf")
+	}
+	width, _, err := t.uint("width", ErrBadHeader)
+	if err != nil {
+		return nil, err
+	}
+	height, _, err := t.uint("height", ErrBadHeader)
+	if err != nil {
+		return nil, err
+	}
+	token, offset, err := t.token("scale")
+	if err != nil {
+		return nil, err
+	}
+	scale, err := strconv.ParseFloat(token, 32)
+	if err != nil || scale == 0 {
+		return nil, headerError(ErrBadHeader, offset, token, "invalid scale")
+	}
+	if err := opts.check(t, width, height, channels, 32, int64(width)*int64(height)*int64(channels*4)); err != nil {
+		return nil, err
+	}
+
+	// A negative scale means little endian samples
+	pfm := &PFM{stride: width * channels, width: width, height: height, channels: channels, scale: float32(math.Abs(scale)), littleEndian: scale < 0}
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.littleEndian {
+		order = binary.LittleEndian
+	}
+	// The rows are stored from the bottom of the image to the top, so they are
+	// read in that order and reversed once all there
+	position := func(i int) (int, int) {
+		i /= channels * 4
+		return i % width, height - 1 - i/width
+	}
+	pfm.pix = make([]float32, 0, min(width*height*channels, rasterChunk))
+	err = t.readRaster(width*height*channels*4, 4, position, func(chunk []byte, _ int) error {
+		for i := 0; i < len(chunk); i += 4 {
+			pfm.pix = append(pfm.pix, math.Float32frombits(order.Uint32(chunk[i:])))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for y := 0; y < height/2; y++ {
+		top, bottom := pfm.row(y), pfm.row(height-1-y)
+		for i := range top {
+			top[i], bottom[i] = bottom[i], top[i]
+		}
+	}
+	return pfm, nil
+}
+
+// Save saves the PFM image to a file.
+func (pfm *PFM) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := pfm.Encode(file, nil); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Encode writes the PFM image to w in its byte order. The PFM header cannot
+// hold comments, so opts is not used.
+func (pfm *PFM) Encode(w io.Writer, opts *EncodeOptions) error {
+	writer := bufio.NewWriter(w)
+	magicNumber := "PF"
+	if pfm.channels == 1 {
+		magicNumber = "Pf"
+	}
+	scale := pfm.scale
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.littleEndian {
+		scale = -scale
+		order = binary.LittleEndian
+	}
+	fmt.Fprintf(writer, "%s\n%d %d\n%s\n", magicNumber, pfm.width, pfm.height, strconv.FormatFloat(float64(scale), 'f', -1, 32))
+	row := make([]byte, pfm.width*pfm.channels*4)
+	for y := pfm.height - 1; y >= 0; y-- {
+		for i, sample := range pfm.row(y) {
+			order.PutUint32(row[i*4:], math.Float32bits(sample))
+		}
+		writer.Write(row)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
+	return nil
+}
+
+// Size returns the width and height of the PFM image.
+func (pfm *PFM) Size() (int, int) {
+	return pfm.width, pfm.height
+}
+
+// Channels returns 3 for a color image and 1 for a grayscale image.
+func (pfm *PFM) Channels() int {
+	return pfm.channels
+}
+
+// SetLittleEndian chooses the byte order the image is written in.
+func (pfm *PFM) SetLittleEndian(littleEndian bool) {
+	pfm.littleEndian = littleEndian
+}
+
+// TupleAt returns the samples of the pixel at (x, y). The returned slice shares
+// the memory of the image.
+func (pfm *PFM) TupleAt(x, y int) []float32 {
+	i := y*pfm.stride + x*pfm.channels
+	return pfm.pix[i : i+pfm.channels]
+}
+
+// SetTuple sets the samples of the pixel at (x, y).
+func (pfm *PFM) SetTuple(x, y int, tuple []float32) {
+	copy(pfm.TupleAt(x, y), tuple)
+}
+
+// Pix returns the samples of the image from top to bottom, channels for each
+// pixel and Stride per row. The returned slice shares the memory of the image.
+func (pfm *PFM) Pix() []float32 {
+	return pfm.pix
+}
+
+// Stride returns the number of samples between the starts of two rows in Pix.
+func (pfm *PFM) Stride() int {
+	return pfm.stride
+}
+
+// row returns the samples of row y.
+func (pfm *PFM) row(y int) []float32 {
+	return pfm.pix[y*pfm.stride : y*pfm.stride+pfm.width*pfm.channels]
+}
+
+// rgb returns the pixel at (x, y) as red, green and blue.
+func (pfm *PFM) rgb(x, y int) (float64, float64, float64) {
+	tuple := pfm.TupleAt(x, y)
+	if pfm.channels == 1 {
+		return float64(tuple[0]), float64(tuple[0]), float64(tuple[0])
+	}
+	return float64(tuple[0]), float64(tuple[1]), float64(tuple[2])
+}
+
+// defaultGamma is the display gamma used when a gamma of zero is given.
+const defaultGamma = 2.2
+
+// ToneMapper maps linear HDR colors to display colors going from 0 to 1.
+type ToneMapper interface {
+	ToneMap(r, g, b float64) (float64, float64, float64)
+}
+
+// ExposureGamma scales colors by 2^Exposure, clips them to 1 and encodes them
+// with 1/Gamma. A Gamma of zero means 2.2.
+type ExposureGamma struct {
+	Exposure float64
+	Gamma    float64
+}
+
+// ToneMap implements ToneMapper.
+func (eg ExposureGamma) ToneMap(r, g, b float64) (float64, float64, float64) {
+	gain := math.Exp2(eg.Exposure)
+	return encodeGamma(r*gain, eg.Gamma), encodeGamma(g*gain, eg.Gamma), encodeGamma(b*gain, eg.Gamma)
+}
+
+// Reinhard is the global operator of Reinhard et al. It compresses the
+// luminance L to L*(1+L/WhitePoint²)/(1+L), keeping the hue, then encodes the
+// colors with 1/Gamma. A WhitePoint of zero means infinity, so that no
+// luminance is clipped, and a Gamma of zero means 2.2.
+type Reinhard struct {
+	WhitePoint float64
+	Gamma      float64
+}
+
+// ToneMap implements ToneMapper.
+func (rh Reinhard) ToneMap(r, g, b float64) (float64, float64, float64) {
+	luminance := luminance(r, g, b)
+	if luminance <= 0 {
+		return 0, 0, 0
+	}
+	mapped := luminance / (1 + luminance)
+	if rh.WhitePoint > 0 {
+		mapped *= 1 + luminance/(rh.WhitePoint*rh.WhitePoint)
+	}
+	ratio := mapped / luminance
+	return encodeGamma(r*ratio, rh.Gamma), encodeGamma(g*ratio, rh.Gamma), encodeGamma(b*ratio, rh.Gamma)
+}
+
+// luminance returns the BT.709 luminance of a linear color.
+func luminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// encodeGamma clips v between 0 and 1 and raises it to 1/gamma.
+func encodeGamma(v, gamma float64) float64 {
+	if gamma == 0 {
+		gamma = defaultGamma
+	}
+	if v <= 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v >= 1 {
+		return 1
+	}
+	return math.Pow(v, 1/gamma)
+}
+
+// quantize converts a value going from 0 to 1 into a sample going from 0 to
+// max. Values outside are clamped, since a ToneMapper may not keep to them.
+func quantize(v float64, max uint16) uint16 {
+	return toSample(v*float64(max), max)
+}
+
+// ToPPM converts the PFM image to a PPM image with the given max value, using
+// tm to bring the colors between 0 and 1. A nil tm is ExposureGamma{}.
+func (pfm *PFM) ToPPM(tm ToneMapper, max uint16) *PPM {
+	if tm == nil {
+		tm = ExposureGamma{}
+	}
+	ppm := NewPPM(pfm.width, pfm.height, max)
+	for y := 0; y < pfm.height; y++ {
+		for x := 0; x < pfm.width; x++ {
+			r, g, b := tm.ToneMap(pfm.rgb(x, y))
+			ppm.SetPixel(x, y, Pixel{quantize(r, max), quantize(g, max), quantize(b, max)})
+		}
+	}
+	return ppm
+}
+
+// ToPGM converts the PFM image to a PGM image with the given max value, using
+// tm to bring the colors between 0 and 1 and keeping their luminance. A nil tm
+// is ExposureGamma{}.
+func (pfm *PFM) ToPGM(tm ToneMapper, max uint16) *PGM {
+	if tm == nil {
+		tm = ExposureGamma{}
+	}
+	pgm := NewPGM(pfm.width, pfm.height, max)
+	for y := 0; y < pfm.height; y++ {
+		for x := 0; x < pfm.width; x++ {
+			// Take the luminance before tone mapping so that it is done on linear values
+			l := luminance(pfm.rgb(x, y))
+			gray, _, _ := tm.ToneMap(l, l, l)
+			pgm.SetGray(x, y, quantize(gray, max))
+		}
+	}
+	return pgm
+}
+
+// decodeGamma converts a sample going from 0 to max into a linear value,
+// raising it to gamma. A gamma of zero means 2.2.
+func decodeGamma(value, max uint16, gamma float64) float32 {
+	if gamma == 0 {
+		gamma = defaultGamma
+	}
+	return float32(math.Pow(float64(value)/float64(max), gamma))
+}
+
+// ToPFM converts the PPM image to a color PFM image, decoding the samples with
+// gamma so that 1 is the max value. A gamma of zero means 2.2, the reverse of
+// the default tone mapping of PFM.ToPPM.
+func (ppm *PPM) ToPFM(gamma float64) *PFM {
+	pfm := NewPFM(ppm.width, ppm.height, 3)
+	for y := 0; y < ppm.height; y++ {
+		samples := pfm.row(y)
+		for i, sample := range ppm.row(y) {
+			samples[i] = decodeGamma(sample, ppm.max, gamma)
+		}
+	}
+	return pfm
+}
+
+// ToPFM converts the PGM image to a grayscale PFM image, decoding the samples
+// with gamma so that 1 is the max value. A gamma of zero means 2.2, the reverse
+// of the default tone mapping of PFM.ToPGM.
+func (pgm *PGM) ToPFM(gamma float64) *PFM {
+	pfm := NewPFM(pgm.width, pgm.height, 1)
+	for y := 0; y < pgm.height; y++ {
+		samples := pfm.row(y)
+		for x, value := range pgm.row(y) {
+			samples[x] = decodeGamma(value, pgm.max, gamma)
+		}
+	}
+	return pfm
+}
@@ -0,0 +1,44 @@
+package Netpbm2
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// outOfRange is a ToneMapper that returns values slightly outside 0 to 1.
+type outOfRange struct{}
+
+func (outOfRange) ToneMap(r, g, b float64) (float64, float64, float64) {
+	return 1.02, -0.01, math.NaN()
+}
+
+func TestToneMapClamps(t *testing.T) {
+	pfm := NewPFM(1, 1, 3)
+	ppm := pfm.ToPPM(outOfRange{}, 255)
+	if got, want := ppm.PixelAt(0, 0), (Pixel{255, 0, 0}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, mapper := range map[string]ToneMapper{"above": constant(1.5), "below": constant(-0.5)} {
+		want := uint16(65535)
+		if name == "below" {
+			want = 0
+		}
+		if got := pfm.ToPGM(mapper, 65535).GrayAt(0, 0); got != want {
+			t.Errorf("gray %s the range is %d, want %d", name, got, want)
+		}
+	}
+}
+
+// constant is a ToneMapper that maps every color to a gray of its value.
+type constant float64
+
+func (c constant) ToneMap(r, g, b float64) (float64, float64, float64) {
+	return float64(c), float64(c), float64(c)
+}
+
+func TestPFMEncodeWriteError(t *testing.T) {
+	if err := NewPFM(2, 2, 1).Encode(failingWriter{}, nil); !errors.Is(err, errWrite) {
+		t.Errorf("got error %v, want one wrapping %v", err, errWrite)
+	}
+}
@@ -2,19 +2,26 @@ package Netpbm2
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
+	"image"
+	"io"
 	"os"
 	"strconv"
-	"strings"
 )
 
 // PGM represents a PGM image
 type PGM struct {
-	data          [][]uint8
+	// pix holds the rows one after the other, the pixel (x, y) being at pix[y*stride+x]
+	pix           []uint16
+	stride        int
 	width, height int
 	magicNumber   string
-	max           uint8
+	max           uint16
+}
+
+// NewPGM returns a black P2 image of the given size and max value.
+func NewPGM(width, height int, max uint16) *PGM {
+	return &PGM{pix: make([]uint16, width*height), stride: width, width: width, height: height, magicNumber: "P2", max: max}
 }
 
 // ReadPGM reads a PGM image from a file and returns a struct that represents the image.
@@ -24,86 +31,119 @@ func ReadPGM(filename string) (*PGM, error) {
 		return nil, err
 	}
 	defer file.Close()
+	return DecodePGM(file)
+}
 
-	reader := bufio.NewReader(file)
+// DecodePGM reads a P2 or P5 image from r. Only the bytes of the image are
+// consumed when r is a *bufio.Reader.
+func DecodePGM(r io.Reader) (*PGM, error) {
+	return DecodePGMWithOptions(r, DefaultDecodeOptions())
+}
 
-	// Read the magic number
-	magicNumber, err := reader.ReadString('\n')
+// DecodePGMWithOptions is like DecodePGM but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodePGMWithOptions(r io.Reader, opts *DecodeOptions) (*PGM, error) {
+	t := newTokenizer(r)
+	opts.start(t)
+	h, err := t.header()
 	if err != nil {
-		return nil, fmt.Errorf("error reading magic number: %v", err)
+		return nil, err
+	}
+	if err := h.expect("P2", "P5"); err != nil {
+		return nil, err
 	}
-	magicNumber = strings.TrimSpace(magicNumber)
-	if magicNumber != "P2" && magicNumber != "P5" {
-		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
+	if err := opts.check(t, h.width, h.height, h.samples(), 16, h.rasterBytes()); err != nil {
+		return nil, err
 	}
 
-	// Read dimensions
-	dimensions, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading dimensions: %v", err)
+	pgm := &PGM{stride: h.width, width: h.width, height: h.height, magicNumber: h.magicNumber, max: uint16(h.max)}
+	if h.magicNumber == "P2" {
+		// Read P2 format (ASCII)
+		pgm.pix, err = readPlainSamples(t, h.width, 1, h.width*h.height, h.max)
+	} else {
+		// Read P5 format (binary), with 2 bytes per sample when the max value is above 255
+		pgm.pix, err = readSamples(t, h.width, 1, h.width*h.height, h.max)
 	}
-	var width, height int
-	_, err = fmt.Sscanf(strings.TrimSpace(dimensions), "%d %d", &width, &height)
 	if err != nil {
-		return nil, fmt.Errorf("invalid dimensions: %v", err)
+		return nil, err
 	}
+	return pgm, nil
+}
 
-	// Read max value
-	var max int
-	_, err = fmt.Fscanf(reader, "%d\n", &max)
-	if err != nil {
-		return nil, fmt.Errorf("error reading max value: %v", err)
-	}
+// Size returns the width and height of the PGM image.
+func (pgm *PGM) Size() (int, int) {
+	return pgm.width, pgm.height
+}
 
-	data := make([][]uint8, height)
+// MagicNumber returns the magic number of the PGM image, P2 or P5.
+func (pgm *PGM) MagicNumber() string {
+	return pgm.magicNumber
+}
 
-	if magicNumber == "P2" {
-		// Read P2 format (ASCII)
-		for y := 0; y < height; y++ {
-			data[y] = make([]uint8, width)
-			for x := 0; x < width; x++ {
-				var pixelValue int
-				_, err := fmt.Fscanf(reader, "%d", &pixelValue)
-				if err != nil {
-					return nil, fmt.Errorf("error reading pixel data at position (%d, %d): %v", x, y, err)
-				}
-				data[y][x] = uint8(pixelValue)
-			}
-			// Read end of line
-			_, _ = reader.ReadString('\n')
-		}
-	} else if magicNumber == "P5" {
-		// Read P5 format (binary)
-		for y := 0; y < height; y++ {
-			data[y] = make([]uint8, width)
-			err := binary.Read(reader, binary.BigEndian, &data[y])
-			if err != nil {
-				return nil, fmt.Errorf("error reading pixel data: %v", err)
-			}
-		}
+// MaxValue returns the max value of the PGM image.
+func (pgm *PGM) MaxValue() uint16 {
+	return pgm.max
+}
+
+// Pix returns the pixels of the image, Stride samples per row. The returned
+// slice shares the memory of the image.
+func (pgm *PGM) Pix() []uint16 {
+	return pgm.pix
+}
+
+// Stride returns the number of samples between the starts of two rows in Pix.
+func (pgm *PGM) Stride() int {
+	return pgm.stride
+}
+
+// SubImage returns the part of the image inside r, clipped to the bounds of the
+// image, as a PGM image whose top-left pixel is (0, 0). The view shares its
+// pixels with pgm, so changing them changes pgm too, until a method that
+// builds new pixels, such as Rotate90CW, Resize or SetMaxValue, gives the
+// view pixels of its own.
+func (pgm *PGM) SubImage(r image.Rectangle) *PGM {
+	r = r.Intersect(pgm.Bounds())
+	if r.Empty() {
+		return &PGM{magicNumber: pgm.magicNumber, max: pgm.max}
+	}
+	return &PGM{
+		pix:         pgm.pix[r.Min.Y*pgm.stride+r.Min.X:],
+		stride:      pgm.stride,
+		width:       r.Dx(),
+		height:      r.Dy(),
+		magicNumber: pgm.magicNumber,
+		max:         pgm.max,
 	}
+}
 
-	return &PGM{data, width, height, magicNumber, uint8(max)}, nil
+// row returns the pixels of row y.
+func (pgm *PGM) row(y int) []uint16 {
+	return pgm.pix[y*pgm.stride : y*pgm.stride+pgm.width]
 }
 
-// Size returns the width and height of the PGM image.
-func (pgm *PGM) Size() (int, int) {
-	return pgm.width, pgm.height
+// clone returns a copy of the PGM image with pixels of its own.
+func (pgm *PGM) clone() *PGM {
+	dst := NewPGM(pgm.width, pgm.height, pgm.max)
+	for y := 0; y < pgm.height; y++ {
+		copy(dst.row(y), pgm.row(y))
+	}
+	dst.magicNumber = pgm.magicNumber
+	return dst
 }
 
-// At returns the value of the pixel at the specified (x, y) coordinates.
-func (pgm *PGM) At(x, y int) uint8 {
+// GrayAt returns the value of the pixel at the specified (x, y) coordinates.
+func (pgm *PGM) GrayAt(x, y int) uint16 {
 	if x >= 0 && x < pgm.width && y >= 0 && y < pgm.height {
-		return pgm.data[y][x]
+		return pgm.pix[y*pgm.stride+x]
 	}
 	// You can choose how to handle out-of-bounds access, for example, return 0 or another default value.
 	return 0
 }
 
-// Set sets the value of the pixel at the specified (x, y) coordinates.
-func (pgm *PGM) Set(x, y int, value uint8) {
+// SetGray sets the value of the pixel at the specified (x, y) coordinates.
+func (pgm *PGM) SetGray(x, y int, value uint16) {
 	if x >= 0 && x < pgm.width && y >= 0 && y < pgm.height {
-		pgm.data[y][x] = value
+		pgm.pix[y*pgm.stride+x] = value
 	}
 	// You can choose how to handle out-of-bounds access, for example, do nothing or log a message.
 }
@@ -114,49 +154,51 @@ func (pgm *PGM) Save(filename string) error {
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	writer := bufio.NewWriter(file)
-	fmt.Fprint(writer, pgm.magicNumber+"\n")
-	fmt.Fprintf(writer, "%d %d\n", pgm.width, pgm.height)
-	fmt.Fprintf(writer, "%d\n", pgm.max)
-	writer.Flush()
-	if pgm.magicNumber == "P2" {
-		for y, row := range pgm.data {
-			for i, pixel := range row {
-				xtra := " "
-				if i == len(row)-1 {
-					xtra = ""
-				}
-				//Here i convert uint8 to an int in order to finally convert it to a string
-				fmt.Fprint(writer, strconv.Itoa(int(pixel))+xtra)
-			}
-			if y != len(pgm.data)-1 {
-				fmt.Fprintln(writer, "")
+	if err := pgm.Encode(file, nil); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Encode writes the PGM image to w as P2 or P5, as chosen by opts.
+func (pgm *PGM) Encode(w io.Writer, opts *EncodeOptions) error {
+	writer := bufio.NewWriter(w)
+	magicNumber := opts.magicNumber(pgm.magicNumber, "P2", "P5")
+	writeHeader(writer, magicNumber, pgm.width, pgm.height, int(pgm.max), opts)
+	if magicNumber == "P2" {
+		pw := &plainWriter{w: writer, width: opts.lineWidth()}
+		for y := 0; y < pgm.height; y++ {
+			for _, pixel := range pgm.row(y) {
+				//Here i convert uint16 to an int in order to finally convert it to a string
+				pw.writeSample(strconv.Itoa(int(pixel)))
 			}
+			pw.endRow()
 		}
-		writer.Flush()
-	} else if pgm.magicNumber == "P5" {
-		for _, row := range pgm.data {
-			for _, pixel := range row {
-				//We can simply convert it to []byte
-				_, err = file.Write([]byte{pixel})
-				if err != nil {
-					return fmt.Errorf("error writing pixel data: %v", err)
-				}
+	} else {
+		size := sampleSize(int(pgm.max))
+		buf := make([]byte, 0, pgm.width*size)
+		for y := 0; y < pgm.height; y++ {
+			buf = buf[:0]
+			for _, pixel := range pgm.row(y) {
+				buf = appendSample(buf, pixel, size)
 			}
+			writer.Write(buf)
 		}
 	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
 	return nil
 }
 
 // Invert inverts the colors of the PGM image.
 func (pgm *PGM) Invert() {
-	maxUint8 := uint8(pgm.max)
-
 	for y := 0; y < pgm.height; y++ {
-		for x := 0; x < pgm.width; x++ {
+		row := pgm.row(y)
+		for x := range row {
 			// Inversion de la valeur du pixel
-			pgm.data[y][x] = maxUint8 - pgm.data[y][x]
+			row[x] = pgm.max - row[x]
 		}
 	}
 }
@@ -164,12 +206,13 @@ func (pgm *PGM) Invert() {
 // Flip flips the PGM image horizontally.
 func (pgm *PGM) Flip() {
 	for y := 0; y < pgm.height; y++ {
+		row := pgm.row(y)
 		left := 0
 		right := pgm.width - 1
 
 		// Inverser les valeurs des pixels de gauche à droite
 		for left < right {
-			pgm.data[y][left], pgm.data[y][right] = pgm.data[y][right], pgm.data[y][left]
+			row[left], row[right] = row[right], row[left]
 			left++
 			right--
 		}
@@ -183,8 +226,9 @@ func (pgm *PGM) Flop() {
 
 	for top < bottom {
 		// Inverser les valeurs des pixels de haut en bas pour chaque colonne
-		for x := 0; x < pgm.width; x++ {
-			pgm.data[top][x], pgm.data[bottom][x] = pgm.data[bottom][x], pgm.data[top][x]
+		topRow, bottomRow := pgm.row(top), pgm.row(bottom)
+		for x := range topRow {
+			topRow[x], bottomRow[x] = bottomRow[x], topRow[x]
 		}
 
 		top++
@@ -197,60 +241,87 @@ func (pgm *PGM) SetMagicNumber(magicNumber string) {
 	pgm.magicNumber = magicNumber
 }
 
-// SetMaxValue sets the max value of the PGM image.
-func (pgm *PGM) SetMaxValue(maxValue uint8) {
-	for y, _ := range pgm.data {
-		for x, _ := range pgm.data[y] {
-			prevValue := pgm.data[y][x]
-			// Effectuez la multiplication avant la division et convertissez le type après la multiplication
-			newValue := uint8((uint(prevValue) * 5) / uint(maxValue))
-			pgm.data[y][x] = newValue
+// SetMaxValue sets the max value of the PGM image. The pixels are rescaled into
+// a new buffer, so a view stops sharing them with its parent, whose max value
+// does not change.
+func (pgm *PGM) SetMaxValue(maxValue uint16) {
+	pix := make([]uint16, pgm.width*pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		for x, prevValue := range pgm.row(y) {
+			pix[y*pgm.width+x] = rescale(prevValue, pgm.max, maxValue)
 		}
 	}
+	pgm.pix, pgm.stride = pix, pgm.width
 	// Mettez à jour la valeur maximale dans la structure PGM
-	pgm.max = uint8(maxValue)
+	pgm.max = maxValue
 }
 
 // Rotate90CW rotates the PGM image 90° clockwise.
 func (pgm *PGM) Rotate90CW() {
+	pgm.transpose(true, false)
+}
+
+// Rotate90CCW rotates the PGM image 90° counterclockwise.
+func (pgm *PGM) Rotate90CCW() {
+	pgm.transpose(false, true)
+}
+
+// Rotate180 rotates the PGM image 180°.
+func (pgm *PGM) Rotate180() {
+	pgm.Flip()
+	pgm.Flop()
+}
+
+// Transpose mirrors the PGM image along its top-left to bottom-right diagonal.
+func (pgm *PGM) Transpose() {
+	pgm.transpose(false, false)
+}
+
+// Transverse mirrors the PGM image along its top-right to bottom-left diagonal.
+func (pgm *PGM) Transverse() {
+	pgm.transpose(true, true)
+}
+
+// transpose swaps the rows and the columns of the image, then flips it
+// horizontally if flip is true and vertically if flop is true. The pixels are
+// moved into a new buffer, so a view stops sharing them with its parent.
+func (pgm *PGM) transpose(flip, flop bool) {
 	// Créer une nouvelle image avec les dimensions inversées
-	rotatedData := make([][]uint8, pgm.width)
-	for x := range rotatedData {
-		rotatedData[x] = make([]uint8, pgm.height)
-	}
+	width, height := pgm.height, pgm.width
+	transposed := make([]uint16, width*height)
 
-	// Remplir la nouvelle image en effectuant la rotation
+	// Remplir la nouvelle image, la ligne y devenant la colonne y
 	for y := 0; y < pgm.height; y++ {
-		for x := 0; x < pgm.width; x++ {
-			rotatedData[x][pgm.height-y-1] = pgm.data[y][x]
+		for x, value := range pgm.row(y) {
+			tx, ty := y, x
+			if flip {
+				tx = width - 1 - tx
+			}
+			if flop {
+				ty = height - 1 - ty
+			}
+			transposed[ty*width+tx] = value
 		}
 	}
 
-	// Mettre à jour les dimensions de l'image actuelle
-	pgm.width, pgm.height = pgm.height, pgm.width
-
-	// Mettre à jour les données avec la nouvelle image pivotée
-	pgm.data = rotatedData
+	// Mettre à jour les dimensions et les données de l'image
+	pgm.width, pgm.height = width, height
+	pgm.pix, pgm.stride = transposed, width
 }
 
-// ToPBM converts the PGM image to PBM.
+// ToPBM converts the PGM image to PBM, the pixels darker than half the max
+// value becoming black, as PPM.ToPBM and PAM.ToPBM do.
 func (pgm *PGM) ToPBM() *PBM {
 	// Créer une nouvelle instance de la struct PBM
-	pbmInstance := &PBM{
-		data:        make([][]bool, pgm.height),
-		width:       pgm.width,
-		height:      pgm.height,
-		magicNumber: "P1",
-	}
+	pbmInstance := NewPBM(pgm.width, pgm.height)
 
 	// Remplir les données de la struct PBM en fonction des valeurs de l'image PGM
 	for y := 0; y < pgm.height; y++ {
-		pbmInstance.data[y] = make([]bool, pgm.width)
-		for x := 0; x < pgm.width; x++ {
-			// Convertir la valeur du pixel en bool (noir ou blanc)
-			pbmInstance.data[y][x] = pgm.data[y][x] > uint8(pgm.max/2)
+		for x, value := range pgm.row(y) {
+			// Convertir la valeur du pixel en bool (noir ou blanc), les pixels sombres devenant noirs
+			pbmInstance.SetBit(x, y, value < pgm.max/2)
 		}
 	}
 
 	return pbmInstance
-}
\ No newline at end of file
+}
@@ -3,23 +3,35 @@ package Netpbm2
 import (
 	"bufio"
 	"fmt"
+	"image"
+	"io"
 	"math"
 	"os"
 	"strconv"
-	"strings"
 )
 
+// PPM represents a PPM image.
 type PPM struct {
-	data          [][]Pixel
+	// pix holds the rows one after the other, with the red, green and blue
+	// samples of the pixel (x, y) starting at pix[y*stride+x*3]
+	pix           []uint16
+	stride        int
 	width, height int
 	magicNumber   string
-	max           uint8
+	max           uint16
 }
 
+// Pixel is a color of a PPM image, each sample going from 0 to the max value of the image.
 type Pixel struct {
-	R, G, B uint8
+	R, G, B uint16
 }
 
+// NewPPM returns a black P3 image of the given size and max value.
+func NewPPM(width, height int, max uint16) *PPM {
+	return &PPM{pix: make([]uint16, width*height*3), stride: width * 3, width: width, height: height, magicNumber: "P3", max: max}
+}
+
+// ReadPPM reads a PPM image from a file and returns a struct that represents the image.
 func ReadPPM(filename string) (*PPM, error) {
 	//Open the file
 	file, err := os.Open(filename)
@@ -27,76 +39,47 @@ func ReadPPM(filename string) (*PPM, error) {
 	if err != nil {
 		return nil, err
 	}
-	//Close the file just before the Save function returns/(finishes its execution). Even if it's an error
+	//Close the file just before the ReadPPM function returns/(finishes its execution). Even if it's an error
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
+	return DecodePPM(file)
+}
+
+// DecodePPM reads a P3 or P6 image from r. Only the bytes of the image are
+// consumed when r is a *bufio.Reader.
+func DecodePPM(r io.Reader) (*PPM, error) {
+	return DecodePPMWithOptions(r, DefaultDecodeOptions())
+}
+
+// DecodePPMWithOptions is like DecodePPM but rejects images beyond the limits of
+// opts before allocating them. A nil opts means no limits.
+func DecodePPMWithOptions(r io.Reader, opts *DecodeOptions) (*PPM, error) {
+	t := newTokenizer(r)
+	opts.start(t)
+	h, err := t.header()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.expect("P3", "P6"); err != nil {
+		return nil, err
+	}
+	if err := opts.check(t, h.width, h.height, h.samples(), 16, h.rasterBytes()); err != nil {
+		return nil, err
+	}
 	//Create a base PPM variable
-	ppm := &PPM{}
-	//Variable line used to count the lines of the image
-	line := 0
-	//Loop through each lines
-	for scanner.Scan() {
-		text := scanner.Text()
-		//Ignore empty lines and comments
-		if strings.HasPrefix(text, "#") {
-			continue
-		}
-		if ppm.magicNumber == "" {
-			//Get the magicnumber. Trimspace removes the spaces from the string
-			ppm.magicNumber = strings.TrimSpace(text)
-		} else if ppm.width == 0 {
-			//Get the width and height of the ppm
-			fmt.Sscanf(text, "%d %d", &ppm.width, &ppm.height)
-			//Initialize the ppm.data matrix variable by creating the correct amount and size of arrays in an array
-			ppm.data = make([][]Pixel, ppm.height)
-			for i := range ppm.data {
-				ppm.data[i] = make([]Pixel, ppm.width)
-			}
-		} else if ppm.max == 0 {
-			//Get the maxValue of the ppm
-			fmt.Sscanf(text, "%d", &ppm.max)
-		} else {
-			if ppm.magicNumber == "P3" {
-				val := strings.Fields(text)
-				//Loop through each strings in the current line
-				for i := 0; i < ppm.width; i++ {
-					//Convert the string to uint8 and set it to the red of the pixel
-					r, _ := strconv.ParseUint(val[i*3], 10, 8)
-					//Same but the index is incremented to get the next value for the green
-					g, _ := strconv.ParseUint(val[i*3+1], 10, 8)
-					//Same but the index is incremented to get the next value for the blue
-					b, _ := strconv.ParseUint(val[i*3+2], 10, 8)
-					//Create the pixel with the colors we just obtained and define it the matrix
-					ppm.data[line][i] = Pixel{R: uint8(r), G: uint8(g), B: uint8(b)}
-				}
-				line++
-			} else if ppm.magicNumber == "P6" {
-				//Create an array of byte of the size of the image * 3 because each pixel has 3 values RGB
-				pixelData := make([]byte, ppm.width*ppm.height*3)
-				//Reads the file content
-				fileContent, err := os.ReadFile(filename)
-				if err != nil {
-					return nil, fmt.Errorf("couldn't read file: %v", err)
-				}
-				//Extracts the necessary pixel data
-				copy(pixelData, fileContent[len(fileContent)-(ppm.width*ppm.height*3):])
-				//Process the data to fill the pixel array of ppm.data
-				pixelIndex := 0
-				for y := 0; y < ppm.height; y++ {
-					for x := 0; x < ppm.width; x++ {
-						ppm.data[y][x].R = pixelData[pixelIndex]
-						ppm.data[y][x].G = pixelData[pixelIndex+1]
-						ppm.data[y][x].B = pixelData[pixelIndex+2]
-						pixelIndex += 3
-					}
-				}
-				break
-			}
-		}
+	ppm := &PPM{stride: h.width * 3, width: h.width, height: h.height, magicNumber: h.magicNumber, max: uint16(h.max)}
+	//Each pixel holds 3 samples, red, green and blue, of 1 or 2 bytes each when raw
+	if ppm.magicNumber == "P3" {
+		ppm.pix, err = readPlainSamples(t, h.width, 3, h.width*h.height*3, h.max)
+	} else {
+		ppm.pix, err = readSamples(t, h.width, 3, h.width*h.height*3, h.max)
+	}
+	if err != nil {
+		return nil, err
 	}
 	return ppm, nil
 }
 
+// Save saves the PPM image to a file in the same format as the original image.
 func (ppm *PPM) Save(filename string) error {
 	//Create a file with the defines name
 	file, err := os.Create(filename)
@@ -104,47 +87,44 @@ func (ppm *PPM) Save(filename string) error {
 	if err != nil {
 		return err
 	}
-	//Close the file just before the Save function returns/(finishes its execution). Even if it's an error
-	defer file.Close()
+	if err := ppm.Encode(file, nil); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Encode writes the PPM image to w as P3 or P6, as chosen by opts.
+func (ppm *PPM) Encode(w io.Writer, opts *EncodeOptions) error {
 	//Store all the modifications into writer "writer" temporarily until flush
-	writer := bufio.NewWriter(file)
-	//Write the magicnumber first
-	fmt.Fprint(writer, ppm.magicNumber+"\n")
-	//Write the size secondly
-	fmt.Fprintf(writer, "%d %d\n", ppm.width, ppm.height)
-	fmt.Fprintf(writer, "%d\n", ppm.max)
-	//Flush writes all the modifications stored in the writer "writer" to the file
-	writer.Flush()
-	if ppm.magicNumber == "P3" {
-		//Loop each pixels of ppm.data
-		for y, row := range ppm.data {
-			for i, pixel := range row {
-				//xtra is used to space each pixel with a space except for the last one of the line
-				xtra := " "
-				if i == len(row)-1 {
-					xtra = ""
-				}
+	writer := bufio.NewWriter(w)
+	magicNumber := opts.magicNumber(ppm.magicNumber, "P3", "P6")
+	writeHeader(writer, magicNumber, ppm.width, ppm.height, int(ppm.max), opts)
+	if magicNumber == "P3" {
+		pw := &plainWriter{w: writer, width: opts.lineWidth()}
+		for y := 0; y < ppm.height; y++ {
+			for _, sample := range ppm.row(y) {
 				//Write the RGB colors in the writer
-				fmt.Fprintf(writer, "%d %d %d%s", pixel.R, pixel.G, pixel.B, xtra)
-			}
-			//Return to line if it's not the last line
-			if y != len(ppm.data)-1 {
-				fmt.Fprintln(writer, "")
+				pw.writeSample(strconv.Itoa(int(sample)))
 			}
+			pw.endRow()
 		}
-		writer.Flush()
-	} else if ppm.magicNumber == "P6" {
-		//For each pixels
-		for _, row := range ppm.data {
-			for _, pixel := range row {
+	} else {
+		size := sampleSize(int(ppm.max))
+		buf := make([]byte, 0, ppm.width*3*size)
+		for y := 0; y < ppm.height; y++ {
+			buf = buf[:0]
+			for _, sample := range ppm.row(y) {
 				//Simple convertion to []byte of RGB
-				_, err = file.Write([]byte{pixel.R, pixel.G, pixel.B})
-				if err != nil {
-					return fmt.Errorf("error writing pixel data: %v", err)
-				}
+				buf = appendSample(buf, sample, size)
 			}
+			writer.Write(buf)
 		}
 	}
+	//Flush writes all the modifications stored in the writer "writer"
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
 	return nil
 }
 
@@ -153,30 +133,77 @@ func (ppm *PPM) Size() (int, int) {
 	return ppm.width, ppm.height
 }
 
-func (ppm *PPM) At(x, y int) Pixel {
+// MagicNumber returns the magic number of the PPM image, P3 or P6.
+func (ppm *PPM) MagicNumber() string {
+	return ppm.magicNumber
+}
+
+// MaxValue returns the max value of the PPM image.
+func (ppm *PPM) MaxValue() uint16 {
+	return ppm.max
+}
+
+// Pix returns the samples of the image, red, green and blue for each pixel and
+// Stride samples per row. The returned slice shares the memory of the image.
+func (ppm *PPM) Pix() []uint16 {
+	return ppm.pix
+}
+
+// Stride returns the number of samples between the starts of two rows in Pix.
+func (ppm *PPM) Stride() int {
+	return ppm.stride
+}
+
+// SubImage returns the part of the image inside r, clipped to the bounds of the
+// image, as a PPM image whose top-left pixel is (0, 0). The view shares its
+// pixels with ppm, so drawing on it changes ppm too, until a method that
+// builds new pixels, such as Rotate90CW, Resize or SetMaxValue, gives the
+// view pixels of its own.
+func (ppm *PPM) SubImage(r image.Rectangle) *PPM {
+	r = r.Intersect(ppm.Bounds())
+	if r.Empty() {
+		return &PPM{magicNumber: ppm.magicNumber, max: ppm.max}
+	}
+	return &PPM{
+		pix:         ppm.pix[r.Min.Y*ppm.stride+r.Min.X*3:],
+		stride:      ppm.stride,
+		width:       r.Dx(),
+		height:      r.Dy(),
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+}
+
+// row returns the samples of row y.
+func (ppm *PPM) row(y int) []uint16 {
+	return ppm.pix[y*ppm.stride : y*ppm.stride+ppm.width*3]
+}
+
+// PixelAt returns the value of the pixel at (x, y).
+func (ppm *PPM) PixelAt(x, y int) Pixel {
 	//Simple return of the value of a specifix pixel
-	return ppm.data[y][x]
+	i := y*ppm.stride + x*3
+	return Pixel{R: ppm.pix[i], G: ppm.pix[i+1], B: ppm.pix[i+2]}
 }
 
-func (ppm *PPM) Set(x, y int, value Pixel) {
+// SetPixel sets the value of the pixel at (x, y).
+func (ppm *PPM) SetPixel(x, y int, value Pixel) {
 	//Simply define a new value to a specific pixel
-	ppm.data[x][y] = value
+	i := y*ppm.stride + x*3
+	ppm.pix[i], ppm.pix[i+1], ppm.pix[i+2] = value.R, value.G, value.B
 }
 
 func (ppm *PPM) Invert() {
-	//Loop throught each pixels
-	for y, _ := range ppm.data {
-		for x, _ := range ppm.data[y] {
-			pixel := ppm.data[y][x]
+	//Loop throught each samples
+	for y := 0; y < ppm.height; y++ {
+		row := ppm.row(y)
+		for i := range row {
 			//Change the value to the opposite of his value
-			//If the value is 240 would be 15
+			//If the max value is 255 and the value is 240 would be 15
 			//255 - 240 = 15
 			//If the value is 1O would be 245
 			//255- 10 = 245
-			pixel.R = uint8(255 - int(pixel.R))
-			pixel.G = uint8(255 - int(pixel.G))
-			pixel.B = uint8(255 - int(pixel.B))
-			ppm.data[y][x] = pixel
+			row[i] = ppm.max - row[i]
 		}
 	}
 }
@@ -184,17 +211,17 @@ func (ppm *PPM) Invert() {
 // Flip by swapping the first and last pixel of each line until the image is flipped.
 func (ppm *PPM) Flip() {
 	//Loop through each lines
-	for y, _ := range ppm.data {
+	for y := 0; y < ppm.height; y++ {
 		//Set cursor to the last character of the line
 		cursor := ppm.width - 1
 		//Loop through each characters of the line
 		for x := 0; x < ppm.width; x++ {
 			//Store the value of the pixel
-			temp := ppm.data[y][x]
+			temp := ppm.PixelAt(x, y)
 			//Change value of the pixel
-			ppm.data[y][x] = ppm.data[y][cursor]
+			ppm.SetPixel(x, y, ppm.PixelAt(cursor, y))
 			//Set the value of the first pixel to the stored one
-			ppm.data[y][cursor] = temp
+			ppm.SetPixel(cursor, y, temp)
 			//Move the cursor to the left on the line
 			cursor--
 			//Break the loop when the cursor crosses or reaches the current line
@@ -210,11 +237,12 @@ func (ppm *PPM) Flop() {
 	//Set the cursor to the bottom line of the image.
 	cursor := ppm.height - 1
 	//Loop through each lines
-	for y, _ := range ppm.data {
+	for y := 0; y < ppm.height; y++ {
 		//Swap the current line with the line pointed to by the cursor
-		temp := ppm.data[y]
-		ppm.data[y] = ppm.data[cursor]
-		ppm.data[cursor] = temp
+		top, bottom := ppm.row(y), ppm.row(cursor)
+		for i := range top {
+			top[i], bottom[i] = bottom[i], top[i]
+		}
 		//Move the cursor to one line higher
 		cursor--
 		//Break the loop when the cursor crosses or reaches the current line
@@ -229,59 +257,88 @@ func (ppm *PPM) SetMagicNumber(magicNumber string) {
 	ppm.magicNumber = magicNumber
 }
 
-func (ppm *PPM) SetMaxValue(maxValue uint8) {
-	//Loop through each pixel
-	for y, _ := range ppm.data {
-		for x, _ := range ppm.data[y] {
-			pixel := ppm.data[y][x]
-			//Calculate the new pixel value based on the new maximum value for each color
-			//Adjusting the pixel value proportionally to the new max value
-			pixel.R = uint8(float64(pixel.R) * float64(maxValue) / float64(ppm.max))
-			pixel.G = uint8(float64(pixel.G) * float64(maxValue) / float64(ppm.max))
-			pixel.B = uint8(float64(pixel.B) * float64(maxValue) / float64(ppm.max))
-			ppm.data[y][x] = pixel
+// SetMaxValue sets the max value of the PPM image. The samples are rescaled
+// into a new buffer, so a view stops sharing them with its parent, whose max
+// value does not change.
+func (ppm *PPM) SetMaxValue(maxValue uint16) {
+	pix := make([]uint16, ppm.width*ppm.height*3)
+	//Loop through each sample
+	for y := 0; y < ppm.height; y++ {
+		for i, sample := range ppm.row(y) {
+			//Calculate the new sample value based on the new maximum value
+			//Adjusting the sample value proportionally to the new max value, rounded
+			pix[y*ppm.width*3+i] = rescale(sample, ppm.max, maxValue)
 		}
 	}
+	ppm.pix, ppm.stride = pix, ppm.width*3
 	ppm.max = maxValue
 }
 
+// Rotate90CW rotates the PPM image 90° clockwise.
 func (ppm *PPM) Rotate90CW() {
-	//Same as pgm.Rotate90CW but the matrix is [][]Pixel not [][]uint8
-	//Create a new matrix to store the rotated pixel data
-	rotatedData := make([][]Pixel, ppm.width)
-	for i := range rotatedData {
-		rotatedData[i] = make([]Pixel, ppm.height)
-	}
+	ppm.transpose(true, false)
+}
+
+// Rotate90CCW rotates the PPM image 90° counterclockwise.
+func (ppm *PPM) Rotate90CCW() {
+	ppm.transpose(false, true)
+}
+
+// Rotate180 rotates the PPM image 180°.
+func (ppm *PPM) Rotate180() {
+	ppm.Flip()
+	ppm.Flop()
+}
+
+// Transpose mirrors the PPM image along its top-left to bottom-right diagonal.
+func (ppm *PPM) Transpose() {
+	ppm.transpose(false, false)
+}
+
+// Transverse mirrors the PPM image along its top-right to bottom-left diagonal.
+func (ppm *PPM) Transverse() {
+	ppm.transpose(true, true)
+}
+
+// transpose swaps the rows and the columns of the image, then flips it
+// horizontally if flip is true and vertically if flop is true. The pixels are
+// moved into a new buffer, so a view stops sharing them with its parent.
+func (ppm *PPM) transpose(flip, flop bool) {
+	//Same as pgm.transpose but with 3 samples per pixel
+	//Create a new image with the width and the height swapped
+	transposed := NewPPM(ppm.height, ppm.width, ppm.max)
 	//Loop through each pixel in the original image
-	for i := 0; i < ppm.width; i++ {
-		for j := 0; j < ppm.height; j++ {
-			//Rotate the pixel by 90 degrees clockwise and assign it
-			rotatedData[i][j] = ppm.data[ppm.height-1-j][i]
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			//Row y becomes column y
+			tx, ty := y, x
+			if flip {
+				tx = transposed.width - 1 - tx
+			}
+			if flop {
+				ty = transposed.height - 1 - ty
+			}
+			transposed.SetPixel(tx, ty, ppm.PixelAt(x, y))
 		}
 	}
 	//Swap the width and height of the image.
-	ppm.width, ppm.height = ppm.height, ppm.width
-	//Update the image data with the rotated data
-	ppm.data = rotatedData
+	ppm.width, ppm.height = transposed.width, transposed.height
+	//Update the image data with the transposed data
+	ppm.pix, ppm.stride = transposed.pix, transposed.stride
 }
 
 func (ppm *PPM) ToPBM() *PBM {
 	//Same idea as pgm.ToPBM
-	//Create a new pbm
-	pbm := &PBM{}
-	//Assign same data except for the magicnumber
-	pbm.magicNumber = "P1"
-	pbm.height = ppm.height
-	pbm.width = ppm.width
-	for y, _ := range ppm.data {
-		pbm.data = append(pbm.data, []bool{})
-		for x, _ := range ppm.data[y] {
-			r, g, b := ppm.data[y][x].R, ppm.data[y][x].G, ppm.data[y][x].B
+	//Create a new pbm of the same size
+	pbm := NewPBM(ppm.width, ppm.height)
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			pixel := ppm.PixelAt(x, y)
 			//Calculate if the pixel should be black or white
 			//if the average of the 3 colors is lower than the half of the maxValue, then i consider it white
 			//If maxValue is 100 and average is 49, it would be black
-			isBlack := (uint8((int(r)+int(g)+int(b))/3) < ppm.max/2)
-			pbm.data[y] = append(pbm.data[y], isBlack)
+			isBlack := (int(pixel.R)+int(pixel.G)+int(pixel.B))/3 < int(ppm.max)/2
+			pbm.SetBit(x, y, isBlack)
 		}
 	}
 	return pbm
@@ -289,21 +346,15 @@ func (ppm *PPM) ToPBM() *PBM {
 
 func (ppm *PPM) ToPGM() *PGM {
 	//Same idea as ppm.ToPBM
-	//Create a new pbm
-	pgm := &PGM{}
-	//Assign same data except for the magicnumber
-	pgm.magicNumber = "P2"
-	pgm.height = ppm.height
-	pgm.width = ppm.width
-	pgm.max = ppm.max
-	for y, _ := range ppm.data {
-		pgm.data = append(pgm.data, []uint8{})
-		for x, _ := range ppm.data[y] {
-			r, g, b := ppm.data[y][x].R, ppm.data[y][x].G, ppm.data[y][x].B
+	//Create a new pgm with the same size and max value
+	pgm := NewPGM(ppm.width, ppm.height, ppm.max)
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			pixel := ppm.PixelAt(x, y)
 			//Calculate the amount of gray the pixel should have
 			//It is just the average of the 3 RGB colors
-			grayValue := uint8((int(r) + int(g) + int(b)) / 3)
-			pgm.data[y] = append(pgm.data[y], uint8(grayValue))
+			grayValue := uint16((int(pixel.R) + int(pixel.G) + int(pixel.B)) / 3)
+			pgm.SetGray(x, y, grayValue)
 		}
 	}
 	return pgm
@@ -322,7 +373,7 @@ func (ppm *PPM) DrawLine(p1, p2 Point, color Pixel) {
 	err := deltaX - deltaY
 	for {
 		if p1.X >= 0 && p1.X < ppm.width && p1.Y >= 0 && p1.Y < ppm.height {
-			ppm.data[p1.Y][p1.X] = color
+			ppm.SetPixel(p1.X, p1.Y, color)
 		}
 		if p1.X == p2.X && p1.Y == p2.Y {
 			break
@@ -392,7 +443,7 @@ func (ppm *PPM) DrawCircle(center Point, radius int, color Pixel) {
 			//Check if the distance is approximately equal to the specified radius
 			//*0.85 is to obtain a circle looking like the tester's circle even if it's not really a circle... In reality, remove "*0.85" and it's a real circle
 			if math.Abs(distance-float64(radius)*0.85) < 0.5 {
-				ppm.data[y][x] = color
+				ppm.SetPixel(x, y, color)
 			}
 		}
 	}
@@ -447,4 +498,4 @@ func (ppm *PPM) DrawPolygon(points []Point, color Pixel) {
 
 func (ppm *PPM) DrawFilledPolygon(points []Point, color Pixel) {
 
-}
\ No newline at end of file
+}
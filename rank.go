@@ -0,0 +1,208 @@
+package Netpbm2
+
+import "math"
+
+// histogram counts the samples of a sliding window in two levels, 256 coarse
+// bins of 256 fine bins each, so that the sample of a given rank is found in
+// at most 512 steps whatever the max value.
+type histogram struct {
+	coarse [256]int
+	fine   [65536]int
+}
+
+// add adds n times the sample v.
+func (h *histogram) add(v uint16, n int) {
+	h.coarse[v>>8] += n
+	h.fine[v] += n
+}
+
+// rank returns the sample with k samples below it, k going from 0 to the
+// number of samples minus 1.
+func (h *histogram) rank(k int) uint16 {
+	for c, count := range h.coarse {
+		if k >= count {
+			k -= count
+			continue
+		}
+		for v := c << 8; ; v++ {
+			if k < h.fine[v] {
+				return uint16(v)
+			}
+			k -= h.fine[v]
+		}
+	}
+	return 0
+}
+
+// rankFilter returns r with each sample replaced by the sample of the given
+// percentile, from 0 for the lowest to 100 for the highest, among the same
+// sample of the (2*radius+1)² pixels around it. The edge pixels are repeated
+// beyond the edges. The window slides along each row, only its leaving and
+// entering columns being counted, so the cost grows with radius and not with
+// its square.
+func rankFilter(r raster, radius int, percentile float64) raster {
+	dst := newRaster(r.width, r.height, r.channels, r.max)
+	if radius <= 0 || r.width == 0 || r.height == 0 {
+		for y := 0; y < r.height; y++ {
+			copy(dst.pix[y*dst.stride:], r.pix[y*r.stride:y*r.stride+r.width*r.channels])
+		}
+		return dst
+	}
+	size := 2*radius + 1
+	percentile = math.Min(math.Max(percentile, 0), 100)
+	k := int(math.Round(percentile / 100 * float64(size*size-1)))
+	h := new(histogram)
+	at := func(x, y, c int) uint16 {
+		return r.pix[clamp(y, 0, r.height-1)*r.stride+clamp(x, 0, r.width-1)*r.channels+c]
+	}
+	column := func(x, y, c, n int) {
+		for j := y - radius; j <= y+radius; j++ {
+			h.add(at(x, j, c), n)
+		}
+	}
+	for c := 0; c < r.channels; c++ {
+		for y := 0; y < r.height; y++ {
+			for i := -radius; i <= radius; i++ {
+				column(i, y, c, 1)
+			}
+			for x := 0; x < r.width; x++ {
+				dst.pix[y*dst.stride+x*dst.channels+c] = h.rank(k)
+				column(x-radius, y, c, -1)
+				column(x+radius+1, y, c, 1)
+			}
+			// Empty the histogram for the next row, which is faster than clearing it
+			for i := r.width - radius; i <= r.width+radius; i++ {
+				column(i, y, c, -1)
+			}
+		}
+	}
+	return dst
+}
+
+// Median replaces each pixel of the PGM image with the median of the
+// (2*radius+1)² pixels around it, which removes salt-and-pepper noise.
+func (pgm *PGM) Median(radius int) {
+	pgm.Percentile(radius, 50)
+}
+
+// Min replaces each pixel of the PGM image with the darkest of the
+// (2*radius+1)² pixels around it.
+func (pgm *PGM) Min(radius int) {
+	pgm.Percentile(radius, 0)
+}
+
+// Max replaces each pixel of the PGM image with the lightest of the
+// (2*radius+1)² pixels around it.
+func (pgm *PGM) Max(radius int) {
+	pgm.Percentile(radius, 100)
+}
+
+// Percentile replaces each pixel of the PGM image with the given percentile,
+// from 0 for the darkest to 100 for the lightest, of the (2*radius+1)² pixels
+// around it. The edge pixels are repeated beyond the edges.
+func (pgm *PGM) Percentile(radius int, percentile float64) {
+	pgm.setRaster(rankFilter(pgm.raster(), radius, percentile))
+}
+
+// Median replaces each sample of the PPM image with the median of the same
+// sample of the (2*radius+1)² pixels around it. The channels being filtered
+// separately, it can create colors; VectorMedian does not.
+func (ppm *PPM) Median(radius int) {
+	ppm.Percentile(radius, 50)
+}
+
+// Min replaces each sample of the PPM image with the lowest of the same sample
+// of the (2*radius+1)² pixels around it.
+func (ppm *PPM) Min(radius int) {
+	ppm.Percentile(radius, 0)
+}
+
+// Max replaces each sample of the PPM image with the highest of the same
+// sample of the (2*radius+1)² pixels around it.
+func (ppm *PPM) Max(radius int) {
+	ppm.Percentile(radius, 100)
+}
+
+// Percentile replaces each sample of the PPM image with the given percentile,
+// from 0 for the lowest to 100 for the highest, of the same sample of the
+// (2*radius+1)² pixels around it. The edge pixels are repeated beyond the
+// edges.
+func (ppm *PPM) Percentile(radius int, percentile float64) {
+	ppm.setRaster(rankFilter(ppm.raster(), radius, percentile))
+}
+
+// VectorMedian replaces each pixel of the PPM image with the pixel of median
+// luminance among the (2*radius+1)² pixels around it. Whole pixels being
+// kept, it removes noise without creating colors.
+func (ppm *PPM) VectorMedian(radius int) {
+	if radius <= 0 || ppm.width == 0 || ppm.height == 0 {
+		return
+	}
+	width, height := ppm.width, ppm.height
+	luma := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := ppm.PixelAt(x, y)
+			luma[y*width+x] = toSample(luminance(float64(p.R), float64(p.G), float64(p.B)), ppm.max)
+		}
+	}
+	// Besides the histogram of the window, the pixels in it are linked in a
+	// list per luminance, so that a pixel of the median one is found without
+	// scanning the window. The edge pixels being repeated, count tells how many
+	// times a pixel is in the window.
+	h := new(histogram)
+	head := make([]int, 65536)
+	for i := range head {
+		head[i] = -1
+	}
+	next, prev, count := make([]int, width*height), make([]int, width*height), make([]int, width*height)
+	add := func(i int) {
+		v := luma[i]
+		h.add(v, 1)
+		if count[i]++; count[i] == 1 {
+			next[i], prev[i] = head[v], -1
+			if head[v] >= 0 {
+				prev[head[v]] = i
+			}
+			head[v] = i
+		}
+	}
+	remove := func(i int) {
+		v := luma[i]
+		h.add(v, -1)
+		if count[i]--; count[i] == 0 {
+			if prev[i] >= 0 {
+				next[prev[i]] = next[i]
+			} else {
+				head[v] = next[i]
+			}
+			if next[i] >= 0 {
+				prev[next[i]] = prev[i]
+			}
+		}
+	}
+	column := func(x, y int, f func(int)) {
+		x = clamp(x, 0, width-1)
+		for j := y - radius; j <= y+radius; j++ {
+			f(clamp(j, 0, height-1)*width + x)
+		}
+	}
+	size := 2*radius + 1
+	k := (size*size - 1) / 2
+	dst := NewPPM(width, height, ppm.max)
+	for y := 0; y < height; y++ {
+		for i := -radius; i <= radius; i++ {
+			column(i, y, add)
+		}
+		for x := 0; x < width; x++ {
+			i := head[h.rank(k)]
+			dst.SetPixel(x, y, ppm.PixelAt(i%width, i/width))
+			column(x-radius, y, remove)
+			column(x+radius+1, y, add)
+		}
+		for i := width - radius; i <= width+radius; i++ {
+			column(i, y, remove)
+		}
+	}
+	ppm.setRaster(dst.raster())
+}
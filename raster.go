@@ -0,0 +1,170 @@
+package Netpbm2
+
+import "math"
+
+// raster is the layout of the samples of a PGM or PPM image, so that the
+// resampling code can handle both: each pixel is channels samples going from
+// 0 to max, the pixel (x, y) starting at pix[y*stride+x*channels].
+type raster struct {
+	pix           []uint16
+	stride        int
+	width, height int
+	channels      int
+	max           uint16
+}
+
+// newRaster returns a raster of the given size filled with zeros.
+func newRaster(width, height, channels int, max uint16) raster {
+	return raster{
+		pix:      make([]uint16, width*height*channels),
+		stride:   width * channels,
+		width:    width,
+		height:   height,
+		channels: channels,
+		max:      max,
+	}
+}
+
+// raster returns the samples of the PGM image.
+func (pgm *PGM) raster() raster {
+	return raster{pix: pgm.pix, stride: pgm.stride, width: pgm.width, height: pgm.height, channels: 1, max: pgm.max}
+}
+
+// setRaster replaces the pixels of the PGM image with those of r.
+func (pgm *PGM) setRaster(r raster) {
+	pgm.pix, pgm.stride, pgm.width, pgm.height = r.pix, r.stride, r.width, r.height
+}
+
+// raster returns the samples of the PPM image.
+func (ppm *PPM) raster() raster {
+	return raster{pix: ppm.pix, stride: ppm.stride, width: ppm.width, height: ppm.height, channels: 3, max: ppm.max}
+}
+
+// setRaster replaces the pixels of the PPM image with those of r.
+func (ppm *PPM) setRaster(r raster) {
+	ppm.pix, ppm.stride, ppm.width, ppm.height = r.pix, r.stride, r.width, r.height
+}
+
+// at returns sample c of the pixel at (x, y), the coordinates being clamped to
+// the edges of the raster.
+func (r raster) at(x, y, c int) float64 {
+	x = clamp(x, 0, r.width-1)
+	y = clamp(y, 0, r.height-1)
+	return float64(r.pix[y*r.stride+x*r.channels+c])
+}
+
+// clamp returns v limited to the range from lo to hi.
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// toSample rounds v to the nearest sample between 0 and max.
+func toSample(v float64, max uint16) uint16 {
+	if v <= 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v >= float64(max) {
+		return max
+	}
+	return uint16(v + 0.5)
+}
+
+// Interpolation chooses how the value of a point that falls between pixel
+// centers is computed when an image is transformed.
+type Interpolation int
+
+const (
+	// InterpolationBilinear blends the 4 nearest pixels. It is the zero value.
+	InterpolationBilinear Interpolation = iota
+	// InterpolationNearest takes the pixel the point falls in, which keeps
+	// the values of the image but gives jagged edges.
+	InterpolationNearest
+	// InterpolationBicubic fits a Catmull-Rom spline through the 16 nearest
+	// pixels, which is sharper than bilinear.
+	InterpolationBicubic
+)
+
+// catmullRom is the Catmull-Rom cubic kernel, which is 1 at 0, 0 at the other
+// integers and 0 beyond 2.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// interpolate stores in out the samples of r at the point (x, y), pixel
+// centers being at half-integer coordinates. Neighbors beyond the edges are
+// the edge pixels.
+func (r raster) interpolate(x, y float64, interpolation Interpolation, out []uint16) {
+	switch interpolation {
+	case InterpolationNearest:
+		px, py := int(math.Floor(x)), int(math.Floor(y))
+		for c := range out {
+			out[c] = uint16(r.at(px, py, c))
+		}
+	case InterpolationBicubic:
+		x, y = x-0.5, y-0.5
+		x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+		var wx, wy [4]float64
+		for i := range wx {
+			wx[i] = catmullRom(x - float64(x0-1+i))
+			wy[i] = catmullRom(y - float64(y0-1+i))
+		}
+		for c := range out {
+			var v float64
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					v += wx[i] * wy[j] * r.at(x0-1+i, y0-1+j, c)
+				}
+			}
+			out[c] = toSample(v, r.max)
+		}
+	default:
+		x, y = x-0.5, y-0.5
+		x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+		fx, fy := x-float64(x0), y-float64(y0)
+		for c := range out {
+			top := r.at(x0, y0, c)*(1-fx) + r.at(x0+1, y0, c)*fx
+			bottom := r.at(x0, y0+1, c)*(1-fx) + r.at(x0+1, y0+1, c)*fx
+			out[c] = toSample(top*(1-fy)+bottom*fy, r.max)
+		}
+	}
+}
+
+// warpEpsilon is how far outside the image a point may fall and still be
+// inside, so that rounding errors do not put background on the edges.
+const warpEpsilon = 1e-6
+
+// warp returns a raster of the given size whose pixels are read from src at the
+// points returned by inverse for their centers. Pixels whose point falls
+// outside src are set to background, as are all of them when src is empty.
+func warp(src raster, width, height int, inverse func(x, y float64) (float64, float64), interpolation Interpolation, background []uint16) raster {
+	dst := newRaster(width, height, src.channels, src.max)
+	empty := src.width == 0 || src.height == 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			out := dst.pix[y*dst.stride+x*dst.channels : (y*dst.stride + (x+1)*dst.channels)]
+			sx, sy := inverse(float64(x)+0.5, float64(y)+0.5)
+			if empty || sx < -warpEpsilon || sy < -warpEpsilon || sx > float64(src.width)+warpEpsilon || sy > float64(src.height)+warpEpsilon {
+				copy(out, background)
+				continue
+			}
+			// Keep the points of the very edge inside for nearest neighbor
+			sx = math.Min(math.Max(sx, 0), float64(src.width)-warpEpsilon)
+			sy = math.Min(math.Max(sy, 0), float64(src.height)-warpEpsilon)
+			src.interpolate(sx, sy, interpolation, out)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,253 @@
+package Netpbm2
+
+import "math"
+
+// Filter chooses the kernel used to resample an image when it is resized.
+type Filter int
+
+const (
+	// FilterBilinear blends the 2 nearest pixels in each direction, a tent
+	// kernel of radius 1. It is the zero value.
+	FilterBilinear Filter = iota
+	// FilterNearest takes the nearest pixel, keeping the values of the image.
+	FilterNearest
+	// FilterBox averages the pixels each new pixel covers, which is the best
+	// choice to shrink by an integer factor.
+	FilterBox
+	// FilterCatmullRom is the sharp cubic kernel also used by
+	// InterpolationBicubic.
+	FilterCatmullRom
+	// FilterMitchell is the Mitchell-Netravali cubic kernel with B = C = 1/3,
+	// softer than Catmull-Rom but with less ringing.
+	FilterMitchell
+	// FilterLanczos3 is the windowed sinc of radius 3, the sharpest of all.
+	FilterLanczos3
+)
+
+// kernel returns the radius of the filter at scale 1 and its weight function.
+func (f Filter) kernel() (float64, func(float64) float64) {
+	switch f {
+	case FilterBox:
+		return 0.5, func(x float64) float64 {
+			if x >= -0.5 && x < 0.5 {
+				return 1
+			}
+			return 0
+		}
+	case FilterCatmullRom:
+		return 2, catmullRom
+	case FilterMitchell:
+		return 2, mitchell
+	case FilterLanczos3:
+		return 3, func(x float64) float64 {
+			if x > -3 && x < 3 {
+				return sinc(x) * sinc(x/3)
+			}
+			return 0
+		}
+	}
+	return 1, func(x float64) float64 {
+		return math.Max(0, 1-math.Abs(x))
+	}
+}
+
+// mitchell is the Mitchell-Netravali cubic kernel with B = C = 1/3.
+func mitchell(x float64) float64 {
+	const b, c = 1.0 / 3, 1.0 / 3
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+// sinc is the normalized sinc function, sin(πx)/(πx).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contribution is the set of source pixels a resized pixel is made of: the
+// pixel first and the following ones, each with its weight. The indexes may
+// go beyond the edges, which stand for the edge pixels.
+type contribution struct {
+	first   int
+	weights []float64
+}
+
+// contributions returns the contribution of each of the out pixels of a row
+// or column of in pixels resized with f. When shrinking, the kernel is
+// stretched so that every source pixel is taken into account.
+func contributions(in, out int, f Filter) []contribution {
+	scale := float64(in) / float64(out)
+	contribs := make([]contribution, out)
+	if f == FilterNearest {
+		for i := range contribs {
+			contribs[i] = contribution{first: int((float64(i) + 0.5) * scale), weights: []float64{1}}
+		}
+		return contribs
+	}
+	support, kernel := f.kernel()
+	filterScale := math.Max(scale, 1)
+	radius := support * filterScale
+	for i := range contribs {
+		center := (float64(i) + 0.5) * scale
+		first := int(math.Floor(center - radius))
+		last := int(math.Ceil(center + radius))
+		weights := make([]float64, 0, last-first)
+		var sum float64
+		for j := first; j < last; j++ {
+			w := kernel((float64(j) + 0.5 - center) / filterScale)
+			weights = append(weights, w)
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= sum
+			}
+		}
+		contribs[i] = contribution{first: first, weights: weights}
+	}
+	return contribs
+}
+
+// resize returns src resized to width*height pixels with f, as a horizontal
+// pass followed by a vertical one.
+func resize(src raster, width, height int, f Filter) raster {
+	dst := newRaster(width, height, src.channels, src.max)
+	if width == 0 || height == 0 || src.width == 0 || src.height == 0 {
+		return dst
+	}
+	channels := src.channels
+	// Horizontal pass, keeping the exact values for the vertical one
+	columns := contributions(src.width, width, f)
+	tmp := make([]float64, src.height*width*channels)
+	for y := 0; y < src.height; y++ {
+		for x, contrib := range columns {
+			out := tmp[(y*width+x)*channels:]
+			for i, w := range contrib.weights {
+				for c := 0; c < channels; c++ {
+					out[c] += w * src.at(contrib.first+i, y, c)
+				}
+			}
+		}
+	}
+	// Vertical pass
+	rows := contributions(src.height, height, f)
+	sum := make([]float64, channels)
+	for y, contrib := range rows {
+		for x := 0; x < width; x++ {
+			for c := range sum {
+				sum[c] = 0
+			}
+			for i, w := range contrib.weights {
+				in := tmp[(clamp(contrib.first+i, 0, src.height-1)*width+x)*channels:]
+				for c := range sum {
+					sum[c] += w * in[c]
+				}
+			}
+			out := dst.pix[y*dst.stride+x*channels:]
+			for c, v := range sum {
+				out[c] = toSample(v, src.max)
+			}
+		}
+	}
+	return dst
+}
+
+// resizeSize returns the size to resize a width*height image to when asked for
+// newWidth*newHeight, a zero dimension being computed from the other one so
+// that the aspect ratio is kept.
+func resizeSize(width, height, newWidth, newHeight int) (int, int) {
+	switch {
+	case newWidth <= 0 && newHeight <= 0:
+		return 0, 0
+	case newWidth <= 0 && height > 0:
+		newWidth = int(math.Max(1, math.Round(float64(width)*float64(newHeight)/float64(height))))
+	case newHeight <= 0 && width > 0:
+		newHeight = int(math.Max(1, math.Round(float64(height)*float64(newWidth)/float64(width))))
+	}
+	return newWidth, newHeight
+}
+
+// fitSize returns the largest size with the aspect ratio of a width*height
+// image that fits in maxWidth*maxHeight, or with cover the smallest one that
+// covers it.
+func fitSize(width, height, maxWidth, maxHeight int, cover bool) (int, int) {
+	if width == 0 || height == 0 || maxWidth <= 0 || maxHeight <= 0 {
+		return 0, 0
+	}
+	scaleX := float64(maxWidth) / float64(width)
+	scaleY := float64(maxHeight) / float64(height)
+	if (scaleX < scaleY) != cover {
+		return maxWidth, int(math.Max(1, math.Round(float64(height)*scaleX)))
+	}
+	return int(math.Max(1, math.Round(float64(width)*scaleY))), maxHeight
+}
+
+// Resize resizes the PGM image to width*height pixels with filter. When width
+// or height is zero, it is computed from the other one to keep the aspect
+// ratio.
+func (pgm *PGM) Resize(width, height int, filter Filter) {
+	width, height = resizeSize(pgm.width, pgm.height, width, height)
+	pgm.setRaster(resize(pgm.raster(), width, height, filter))
+}
+
+// Fit resizes the PGM image to the largest size that fits in maxWidth*maxHeight
+// while keeping its aspect ratio.
+func (pgm *PGM) Fit(maxWidth, maxHeight int, filter Filter) {
+	width, height := fitSize(pgm.width, pgm.height, maxWidth, maxHeight, false)
+	pgm.Resize(width, height, filter)
+}
+
+// Fill resizes the PGM image to the smallest size that covers width*height
+// while keeping its aspect ratio, then crops it around its center to exactly
+// width*height.
+func (pgm *PGM) Fill(width, height int, filter Filter) {
+	w, h := fitSize(pgm.width, pgm.height, width, height, true)
+	pgm.Resize(w, h, filter)
+	pgm.setRaster(pgm.raster().center(width, height))
+}
+
+// Resize resizes the PPM image to width*height pixels with filter. When width
+// or height is zero, it is computed from the other one to keep the aspect
+// ratio.
+func (ppm *PPM) Resize(width, height int, filter Filter) {
+	width, height = resizeSize(ppm.width, ppm.height, width, height)
+	ppm.setRaster(resize(ppm.raster(), width, height, filter))
+}
+
+// Fit resizes the PPM image to the largest size that fits in maxWidth*maxHeight
+// while keeping its aspect ratio.
+func (ppm *PPM) Fit(maxWidth, maxHeight int, filter Filter) {
+	width, height := fitSize(ppm.width, ppm.height, maxWidth, maxHeight, false)
+	ppm.Resize(width, height, filter)
+}
+
+// Fill resizes the PPM image to the smallest size that covers width*height
+// while keeping its aspect ratio, then crops it around its center to exactly
+// width*height.
+func (ppm *PPM) Fill(width, height int, filter Filter) {
+	w, h := fitSize(ppm.width, ppm.height, width, height, true)
+	ppm.Resize(w, h, filter)
+	ppm.setRaster(ppm.raster().center(width, height))
+}
+
+// center returns the width*height pixels at the center of r, which must be at
+// least that large. The returned raster shares the samples of r.
+func (r raster) center(width, height int) raster {
+	width, height = min(width, r.width), min(height, r.height)
+	x, y := (r.width-width)/2, (r.height-height)/2
+	if width <= 0 || height <= 0 {
+		return newRaster(0, 0, r.channels, r.max)
+	}
+	r.pix = r.pix[y*r.stride+x*r.channels:]
+	r.width, r.height = width, height
+	return r
+}
@@ -0,0 +1,83 @@
+package Netpbm2
+
+import "math"
+
+// RotateOptions controls how an image is rotated. A nil *RotateOptions is the
+// same as the zero value: bilinear interpolation and an expanded canvas.
+type RotateOptions struct {
+	// Interpolation chooses how the rotated pixels are computed. PBM images
+	// always use InterpolationNearest.
+	Interpolation Interpolation
+	// Crop keeps the size of the image, cutting the corners that rotate out
+	// of it, instead of expanding the canvas to hold the whole rotated image.
+	Crop bool
+}
+
+// interpolation returns the interpolation to use.
+func (opts *RotateOptions) interpolation() Interpolation {
+	if opts == nil {
+		return InterpolationBilinear
+	}
+	return opts.Interpolation
+}
+
+// rotation returns the size of an image of width*height pixels rotated by
+// angle degrees counterclockwise, and the function mapping a point of the
+// rotated image back to the original one. Both images have the same center.
+func rotation(width, height int, angle float64, opts *RotateOptions) (int, int, func(x, y float64) (float64, float64)) {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	// Snap the right angles so that they do not leave a line of background
+	for _, v := range []*float64{&sin, &cos} {
+		if math.Abs(*v) < 1e-12 {
+			*v = 0
+		}
+	}
+	newWidth, newHeight := width, height
+	if opts == nil || !opts.Crop {
+		w, h := float64(width), float64(height)
+		newWidth = int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - 1e-9))
+		newHeight = int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - 1e-9))
+	}
+	cx, cy := float64(width)/2, float64(height)/2
+	ncx, ncy := float64(newWidth)/2, float64(newHeight)/2
+	inverse := func(x, y float64) (float64, float64) {
+		dx, dy := x-ncx, y-ncy
+		return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos
+	}
+	return newWidth, newHeight, inverse
+}
+
+// Rotate rotates the PGM image by angle degrees counterclockwise around its
+// center. The areas the image does not cover are set to background.
+func (pgm *PGM) Rotate(angle float64, background uint16, opts *RotateOptions) {
+	width, height, inverse := rotation(pgm.width, pgm.height, angle, opts)
+	pgm.setRaster(warp(pgm.raster(), width, height, inverse, opts.interpolation(), []uint16{background}))
+}
+
+// Rotate rotates the PPM image by angle degrees counterclockwise around its
+// center. The areas the image does not cover are set to background.
+func (ppm *PPM) Rotate(angle float64, background Pixel, opts *RotateOptions) {
+	width, height, inverse := rotation(ppm.width, ppm.height, angle, opts)
+	ppm.setRaster(warp(ppm.raster(), width, height, inverse, opts.interpolation(), []uint16{background.R, background.G, background.B}))
+}
+
+// Rotate rotates the PBM image by angle degrees counterclockwise around its
+// center, taking the nearest pixel. The areas the image does not cover are set
+// to background, true being black.
+func (pbm *PBM) Rotate(angle float64, background bool, opts *RotateOptions) {
+	width, height, inverse := rotation(pbm.width, pbm.height, angle, opts)
+	rotated := NewPBM(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := inverse(float64(x)+0.5, float64(y)+0.5)
+			px, py := int(math.Floor(sx)), int(math.Floor(sy))
+			if sx < 0 || sy < 0 || px >= pbm.width || py >= pbm.height {
+				rotated.SetBit(x, y, background)
+			} else {
+				rotated.SetBit(x, y, pbm.BitAt(px, py))
+			}
+		}
+	}
+	rotated.magicNumber = pbm.magicNumber
+	*pbm = *rotated
+}
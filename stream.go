@@ -0,0 +1,65 @@
+package Netpbm2
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// StreamReader reads the images of a stream one after the other. The
+// specification allows PBM, PGM, PPM and PAM images to be concatenated in a
+// single file, with any whitespace between them, and the types can be mixed.
+type StreamReader struct {
+	t    *tokenizer
+	opts *DecodeOptions
+}
+
+// NewStreamReader returns a StreamReader reading from r with the limits of
+// DefaultDecodeOptions().
+func NewStreamReader(r io.Reader) *StreamReader {
+	return NewStreamReaderWithOptions(r, DefaultDecodeOptions())
+}
+
+// NewStreamReaderWithOptions returns a StreamReader reading from r, applying
+// the limits of opts to each image. A nil opts means no limits.
+func NewStreamReaderWithOptions(r io.Reader, opts *DecodeOptions) *StreamReader {
+	return &StreamReader{t: newTokenizer(r), opts: opts}
+}
+
+// Next returns the next image of the stream: a *PBM, *PGM, *PPM or *PAM. It
+// returns io.EOF when the stream ends cleanly after the previous image.
+func (sr *StreamReader) Next() (image.Image, error) {
+	// Skip the whitespace that may follow the previous image
+	sr.t.limit = 0
+	if err := sr.t.skipBlank(); err != nil {
+		return nil, err
+	}
+	return DecodeWithOptions(sr.t, sr.opts)
+}
+
+// StreamWriter writes images one after the other into a single stream.
+type StreamWriter struct {
+	w    io.Writer
+	opts *EncodeOptions
+}
+
+// NewStreamWriter returns a StreamWriter writing to w, encoding every image
+// with opts.
+func NewStreamWriter(w io.Writer, opts *EncodeOptions) *StreamWriter {
+	return &StreamWriter{w: w, opts: opts}
+}
+
+// WriteImage appends img to the stream. Images of this package are written in
+// their own format; any other image is converted with NewPPMFromImage.
+func (sw *StreamWriter) WriteImage(img image.Image) error {
+	encoder, ok := img.(interface {
+		Encode(w io.Writer, opts *EncodeOptions) error
+	})
+	if !ok {
+		encoder = NewPPMFromImage(img)
+	}
+	if err := encoder.Encode(sw.w, sw.opts); err != nil {
+		return fmt.Errorf("error writing image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package Netpbm2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// header holds the values found at the start of a PBM, PGM or PPM image.
+type header struct {
+	magicNumber   string
+	width, height int
+	max           int
+	// offset is the position of the magic number in the stream
+	offset int64
+}
+
+// tokenizer splits a Netpbm stream into the tokens of its headers and plain
+// rasters, following the specification: tokens are separated by any amount of
+// whitespace, a comment goes from "#" to the end of the line and counts as
+// whitespace, and the header of an image ends with exactly one whitespace
+// character. It keeps track of the offset of every byte it reads so that errors
+// can tell where they happened.
+type tokenizer struct {
+	r      *bufio.Reader
+	offset int64
+	// limit is the offset that must not be reached, 0 for no limit
+	limit int64
+}
+
+// newTokenizer returns a tokenizer reading from r. When r is already a
+// tokenizer it is returned as is, so that offsets keep counting from the start
+// of the stream, and when r is a *bufio.Reader it is used directly so that
+// nothing past the image is consumed.
+func newTokenizer(r io.Reader) *tokenizer {
+	switch r := r.(type) {
+	case *tokenizer:
+		return r
+	case *bufio.Reader:
+		return &tokenizer{r: r}
+	}
+	return &tokenizer{r: bufio.NewReader(r)}
+}
+
+// Read reads raw raster bytes, counting them in the offset.
+func (t *tokenizer) Read(p []byte) (int, error) {
+	if t.limit > 0 {
+		if t.offset >= t.limit {
+			return 0, limitError(t.offset, "image is larger than the byte limit")
+		}
+		if int64(len(p)) > t.limit-t.offset {
+			p = p[:t.limit-t.offset]
+		}
+	}
+	n, err := t.r.Read(p)
+	t.offset += int64(n)
+	return n, err
+}
+
+// rasterChunk is the most bytes of a raw raster read at once. The readers grow
+// their pixels chunk by chunk instead of allocating them from the header, so a
+// header claiming a huge image costs little memory unless its data is there.
+const rasterChunk = 1 << 16
+
+// readRaster reads the n bytes of a raw raster in chunks of at most
+// rasterChunk bytes, each a multiple of size, and passes them to f with the
+// index of their first byte in the raster. When the raster is truncated,
+// position gives the pixel holding the missing byte of the given index.
+func (t *tokenizer) readRaster(n, size int, position func(i int) (int, int), f func(chunk []byte, start int) error) error {
+	chunk := make([]byte, min(n, rasterChunk/size*size))
+	for start := 0; start < n; {
+		buf := chunk[:min(n-start, len(chunk))]
+		if read, err := io.ReadFull(t, buf); err != nil {
+			x, y := position(start + read)
+			return t.rasterError(err, x, y)
+		}
+		if err := f(buf, start); err != nil {
+			return err
+		}
+		start += len(buf)
+	}
+	return nil
+}
+
+// readByte reads one byte, counting it in the offset.
+func (t *tokenizer) readByte() (byte, error) {
+	if t.limit > 0 && t.offset >= t.limit {
+		return 0, limitError(t.offset, "image is larger than the byte limit")
+	}
+	c, err := t.r.ReadByte()
+	if err == nil {
+		t.offset++
+	}
+	return c, err
+}
+
+// unreadByte puts back the byte that was just read.
+func (t *tokenizer) unreadByte() {
+	if t.r.UnreadByte() == nil {
+		t.offset--
+	}
+}
+
+// peek returns the next n bytes without consuming them.
+func (t *tokenizer) peek(n int) ([]byte, error) {
+	return t.r.Peek(n)
+}
+
+// isSpace reports whether c is one of the whitespace characters allowed by the
+// Netpbm specification.
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// skipComment discards everything up to and including the end of the line,
+// which is either a newline or a carriage return.
+func (t *tokenizer) skipComment() error {
+	for {
+		c, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		if c == '\n' || c == '\r' {
+			return nil
+		}
+	}
+}
+
+// skipSpace discards whitespace and comments and returns the first byte after them.
+func (t *tokenizer) skipSpace() (byte, error) {
+	for {
+		c, err := t.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == '#' {
+			if err := t.skipComment(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return c, nil
+		}
+	}
+}
+
+// skipBlank discards the whitespace between two images of a stream. It returns
+// io.EOF when the stream ends.
+func (t *tokenizer) skipBlank() error {
+	for {
+		c, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		if !isSpace(c) {
+			t.unreadByte()
+			return nil
+		}
+	}
+}
+
+// token returns the next token and its offset. The single whitespace character
+// or the comment ending the token is consumed, so after the last token of a
+// header the tokenizer is at the first byte of the raster. what names the token
+// in the error returned when the stream ends before it.
+func (t *tokenizer) token(what string) (string, int64, error) {
+	c, err := t.skipSpace()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "", t.offset, headerError(ErrTruncated, t.offset, "", "missing "+what)
+	}
+	if err != nil {
+		return "", t.offset, err
+	}
+	offset := t.offset - 1
+	token := []byte{c}
+	for {
+		c, err = t.readByte()
+		if err == io.EOF {
+			return string(token), offset, nil
+		}
+		if err != nil {
+			return "", offset, err
+		}
+		if c == '#' {
+			if err := t.skipComment(); err != nil && err != io.EOF {
+				return "", offset, err
+			}
+			return string(token), offset, nil
+		}
+		if isSpace(c) {
+			return string(token), offset, nil
+		}
+		token = append(token, c)
+	}
+}
+
+// uint reads the next token as an unsigned decimal number and returns it with
+// its offset. Only the digits 0 to 9 are allowed, without any sign. kind is the
+// error wrapped when the token is not a number.
+func (t *tokenizer) uint(what string, kind error) (int, int64, error) {
+	token, offset, err := t.token(what)
+	if err != nil {
+		return 0, offset, err
+	}
+	for i := 0; i < len(token); i++ {
+		if token[i] < '0' || token[i] > '9' {
+			return 0, offset, headerError(kind, offset, token, "invalid "+what)
+		}
+	}
+	value, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, offset, headerError(kind, offset, token, what+" is too large")
+	}
+	return value, offset, nil
+}
+
+// bit reads the next 0 or 1 of a plain PBM raster, which may or may not be
+// separated from its neighbours by whitespace.
+func (t *tokenizer) bit() (bool, error) {
+	c, err := t.skipSpace()
+	if err == io.EOF {
+		return false, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	if c != '0' && c != '1' {
+		return false, headerError(ErrBadSample, t.offset-1, string(c), "invalid bit")
+	}
+	return c == '1', nil
+}
+
+// magicNumber reads the two bytes of a magic number, which must be followed by
+// whitespace or a comment.
+func (t *tokenizer) magicNumber() (string, int64, error) {
+	offset := t.offset
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(t, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return "", offset, headerError(ErrTruncated, offset, "", "missing magic number")
+		}
+		return "", offset, fmt.Errorf("error reading magic number: %w", err)
+	}
+	next, err := t.peek(1)
+	if magic[0] != 'P' || (err == nil && !isSpace(next[0]) && next[0] != '#') {
+		return "", offset, headerError(ErrBadMagic, offset, string(magic)+string(next), "")
+	}
+	return string(magic), offset, nil
+}
+
+// line returns the rest of the current line without its newline, and its
+// offset. PAM headers are made of lines rather than tokens.
+func (t *tokenizer) line() (string, int64, error) {
+	offset := t.offset
+	var line []byte
+	for {
+		c, err := t.readByte()
+		if err == io.EOF {
+			return "", offset, headerError(ErrTruncated, t.offset, "", "header is truncated")
+		}
+		if err != nil {
+			return "", offset, err
+		}
+		if c == '\n' {
+			return string(line), offset, nil
+		}
+		line = append(line, c)
+	}
+}
+
+// header reads the magic number, the dimensions and, for PGM and PPM, the max
+// value. The single whitespace that ends the header is consumed, so the
+// tokenizer is left at the first byte of the raster.
+func (t *tokenizer) header() (header, error) {
+	var h header
+	var err error
+	h.magicNumber, h.offset, err = t.magicNumber()
+	if err != nil {
+		return h, err
+	}
+	switch h.magicNumber {
+	case "P1", "P2", "P3", "P4", "P5", "P6":
+	default:
+		return h, headerError(ErrBadMagic, h.offset, h.magicNumber, "")
+	}
+	if h.width, _, err = t.uint("width", ErrBadHeader); err != nil {
+		return h, err
+	}
+	if h.height, _, err = t.uint("height", ErrBadHeader); err != nil {
+		return h, err
+	}
+	// PBM has no max value, a pixel is either 0 or 1
+	if h.magicNumber == "P1" || h.magicNumber == "P4" {
+		h.max = 1
+		return h, nil
+	}
+	var offset int64
+	if h.max, offset, err = t.uint("max value", ErrBadHeader); err != nil {
+		return h, err
+	}
+	if h.max < 1 || h.max > 65535 {
+		return h, headerError(ErrBadHeader, offset, strconv.Itoa(h.max), "max value must go from 1 to 65535")
+	}
+	return h, nil
+}
+
+// expect returns an error unless the magic number of h is one of magicNumbers.
+func (h header) expect(magicNumbers ...string) error {
+	for _, magicNumber := range magicNumbers {
+		if h.magicNumber == magicNumber {
+			return nil
+		}
+	}
+	return headerError(ErrBadMagic, h.offset, h.magicNumber, fmt.Sprintf("magic number is not %v", magicNumbers))
+}
+
+// samples returns the number of samples of each pixel of the image described by h.
+func (h header) samples() int {
+	if h.magicNumber == "P3" || h.magicNumber == "P6" {
+		return 3
+	}
+	return 1
+}
+
+// rasterBytes returns the least number of bytes the raster of the image
+// described by h can take: its exact size for a raw image, one byte per sample
+// for a plain one.
+func (h header) rasterBytes() int64 {
+	pixels := int64(h.width) * int64(h.height)
+	switch h.magicNumber {
+	case "P4":
+		return int64((h.width+7)/8) * int64(h.height)
+	case "P5", "P6":
+		return pixels * int64(h.samples()*sampleSize(h.max))
+	}
+	return pixels * int64(h.samples())
+}
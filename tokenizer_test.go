@@ -0,0 +1,145 @@
+package Netpbm2
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// checkFormatError fails t unless err is a FormatError of the given kind,
+// offset and token.
+func checkFormatError(t *testing.T, err error, kind error, offset int64, token string) *FormatError {
+	t.Helper()
+	var formatError *FormatError
+	if !errors.As(err, &formatError) {
+		t.Fatalf("got error %v, want a FormatError", err)
+	}
+	if !errors.Is(err, kind) {
+		t.Errorf("got error %v, want %v", formatError.Err, kind)
+	}
+	if formatError.Offset != offset {
+		t.Errorf("got offset %d, want %d", formatError.Offset, offset)
+	}
+	if formatError.Token != token {
+		t.Errorf("got token %q, want %q", formatError.Token, token)
+	}
+	return formatError
+}
+
+func TestTokenizerHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   header
+		raster string
+	}{
+		{"one line", "P5 3 2 255\nAB", header{magicNumber: "P5", width: 3, height: 2, max: 255}, "AB"},
+		{"one token per line", "P2\n3\n2\n255\n1 2", header{magicNumber: "P2", width: 3, height: 2, max: 255}, "1 2"},
+		{"width and height split", "P3 3\n\n 2 65535\n1", header{magicNumber: "P3", width: 3, height: 2, max: 65535}, "1"},
+		{"comments after tokens", "P2# magic\n3# width\n2 # height\n15\n1", header{magicNumber: "P2", width: 3, height: 2, max: 15}, "1"},
+		{"comment before the raster", "P5 1 1 255# max\n\n", header{magicNumber: "P5", width: 1, height: 1, max: 255}, "\n"},
+		{"comment ended by carriage return", "P5 1 1 # dimensions\r255\r\r", header{magicNumber: "P5", width: 1, height: 1, max: 255}, "\r"},
+		{"tabs and form feeds", "P6\t2\f1\v255\tXYZ", header{magicNumber: "P6", width: 2, height: 1, max: 255}, "XYZ"},
+		{"PBM has no max value", "P4 8 1\n1", header{magicNumber: "P4", width: 8, height: 1, max: 1}, "1"},
+		{"single whitespace before the raster", "P5 2 1 255\n\n\n", header{magicNumber: "P5", width: 2, height: 1, max: 255}, "\n\n"},
+		{"carriage return line feed", "P4\r\n8 1\r\nX", header{magicNumber: "P4", width: 8, height: 1, max: 1}, "\nX"},
+		{"zero width", "P2 0 5 255\n", header{magicNumber: "P2", width: 0, height: 5, max: 255}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tok := newTokenizer(strings.NewReader(test.input))
+			h, err := tok.header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h != test.want {
+				t.Errorf("got header %+v, want %+v", h, test.want)
+			}
+			raster, err := io.ReadAll(tok)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raster) != test.raster {
+				t.Errorf("got raster %q, want %q", raster, test.raster)
+			}
+			if want := int64(len(test.input) - len(test.raster)); tok.offset-int64(len(raster)) != want {
+				t.Errorf("header ends at offset %d, want %d", tok.offset-int64(len(raster)), want)
+			}
+		})
+	}
+}
+
+func TestTokenizerHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		kind   error
+		offset int64
+		token  string
+	}{
+		{"empty", "", ErrTruncated, 0, ""},
+		{"unknown magic number", "P9 1 1\n", ErrBadMagic, 0, "P9"},
+		{"magic number not followed by whitespace", "P5x 1 1 255\n", ErrBadMagic, 0, "P5x"},
+		{"letter in width", "P5 3x 2 255\n", ErrBadHeader, 3, "3x"},
+		{"negative height", "P2 3\n-2 255\n", ErrBadHeader, 5, "-2"},
+		{"width too large", "P2 99999999999999999999 1 1\n", ErrBadHeader, 3, "99999999999999999999"},
+		{"max value too large", "P2 3 2 70000\n", ErrBadHeader, 7, "70000"},
+		{"zero max value", "P2 3 2\n# max\n0\n", ErrBadHeader, 13, "0"},
+		{"missing height", "P2 3", ErrTruncated, 4, ""},
+		{"missing max value after comment", "P5 3 2 # max", ErrTruncated, 12, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newTokenizer(strings.NewReader(test.input)).header()
+			formatError := checkFormatError(t, err, test.kind, test.offset, test.token)
+			if formatError.X != -1 || formatError.Y != -1 {
+				t.Errorf("got pixel (%d, %d), want none", formatError.X, formatError.Y)
+			}
+		})
+	}
+}
+
+func TestTokenizerToken(t *testing.T) {
+	tok := newTokenizer(strings.NewReader("#c\nab  cd#x\nef\tgh#y\rij"))
+	want := []struct {
+		token  string
+		offset int64
+	}{{"ab", 3}, {"cd", 7}, {"ef", 12}, {"gh", 15}, {"ij", 20}}
+	for _, w := range want {
+		token, offset, err := tok.token("token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != w.token || offset != w.offset {
+			t.Errorf("got token %q at %d, want %q at %d", token, offset, w.token, w.offset)
+		}
+	}
+	_, _, err := tok.token("token")
+	checkFormatError(t, err, ErrTruncated, 22, "")
+}
+
+func TestTokenizerBit(t *testing.T) {
+	tok := newTokenizer(strings.NewReader("0 1\n10# comment 0\n 1\t0"))
+	for i, want := range []bool{false, true, true, false, true, false} {
+		bit, err := tok.bit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bit != want {
+			t.Errorf("bit %d is %v, want %v", i, bit, want)
+		}
+	}
+	if _, err := tok.bit(); err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v at the end, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	tok = newTokenizer(strings.NewReader("01 2"))
+	for i := 0; i < 2; i++ {
+		if _, err := tok.bit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := tok.bit()
+	checkFormatError(t, err, ErrBadSample, 3, "2")
+}
@@ -0,0 +1,175 @@
+package Netpbm2
+
+import (
+	"fmt"
+	"math"
+)
+
+// Matrix is a 2D affine transform mapping the point (x, y) of an image to the
+// point (m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]). Points are in pixel
+// units, (0, 0) being the top-left corner of the image and (0.5, 0.5) the
+// center of its first pixel.
+type Matrix [6]float64
+
+// invert returns the transform undoing m, and false when there is none.
+func (m Matrix) invert() (Matrix, bool) {
+	det := m[0]*m[4] - m[1]*m[3]
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Matrix{}, false
+	}
+	return Matrix{
+		m[4] / det, -m[1] / det, (m[1]*m[5] - m[2]*m[4]) / det,
+		-m[3] / det, m[0] / det, (m[2]*m[3] - m[0]*m[5]) / det,
+	}, true
+}
+
+// apply returns the point (x, y) transformed by m.
+func (m Matrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// WarpOptions controls how an image is warped. A nil *WarpOptions is the same
+// as the zero value: bilinear interpolation and the size of the image.
+type WarpOptions struct {
+	// Interpolation chooses how the warped pixels are computed.
+	Interpolation Interpolation
+	// Width and Height are the size of the warped image, zero meaning the
+	// size of the image before warping.
+	Width, Height int
+}
+
+// interpolation returns the interpolation to use.
+func (opts *WarpOptions) interpolation() Interpolation {
+	if opts == nil {
+		return InterpolationBilinear
+	}
+	return opts.Interpolation
+}
+
+// size returns the size of the warped image for an image of width*height pixels.
+func (opts *WarpOptions) size(width, height int) (int, int) {
+	if opts != nil && opts.Width > 0 {
+		width = opts.Width
+	}
+	if opts != nil && opts.Height > 0 {
+		height = opts.Height
+	}
+	return width, height
+}
+
+// homography returns the projective transform mapping each point of dst to
+// the point of src with the same index, and false when the points do not
+// define one, for instance because three of them are on the same line.
+func homography(src, dst [4]Point) (func(x, y float64) (float64, float64), bool) {
+	// Solve the 8 equations u*(h6*x + h7*y + 1) = h0*x + h1*y + h2 and
+	// v*(h6*x + h7*y + 1) = h3*x + h4*y + h5, (x, y) being in dst and (u, v) in src
+	var a [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y := float64(dst[i].X), float64(dst[i].Y)
+		u, v := float64(src[i].X), float64(src[i].Y)
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -x * u, -y * u, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -x * v, -y * v, v}
+	}
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			f := a[row][col] / a[col][col]
+			for k := col; k < 9; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+	var h [8]float64
+	for i := range h {
+		h[i] = a[i][8] / a[i][i]
+	}
+	return func(x, y float64) (float64, float64) {
+		w := h[6]*x + h[7]*y + 1
+		return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w
+	}, true
+}
+
+// affineInverse returns the function mapping a point of the warped image back
+// to the image for the transform m.
+func affineInverse(m Matrix) (func(x, y float64) (float64, float64), error) {
+	inverse, ok := m.invert()
+	if !ok {
+		return nil, fmt.Errorf("matrix %v is not invertible", m)
+	}
+	return inverse.apply, nil
+}
+
+// perspectiveInverse returns the function mapping a point of the warped image
+// back to the image for the transform taking src to dst.
+func perspectiveInverse(src, dst [4]Point) (func(x, y float64) (float64, float64), error) {
+	inverse, ok := homography(src, dst)
+	if !ok {
+		return nil, fmt.Errorf("cannot map quadrilateral %v to %v", src, dst)
+	}
+	return inverse, nil
+}
+
+// Affine transforms the PGM image by m. The areas the image does not cover are
+// set to background.
+func (pgm *PGM) Affine(m Matrix, background uint16, opts *WarpOptions) error {
+	inverse, err := affineInverse(m)
+	if err != nil {
+		return err
+	}
+	width, height := opts.size(pgm.width, pgm.height)
+	pgm.setRaster(warp(pgm.raster(), width, height, inverse, opts.interpolation(), []uint16{background}))
+	return nil
+}
+
+// Perspective applies to the PGM image the projective transform that takes the
+// corners of src to those of dst, such as the corners of a photographed page
+// to those of a rectangle. The areas the image does not cover are set to
+// background.
+func (pgm *PGM) Perspective(src, dst [4]Point, background uint16, opts *WarpOptions) error {
+	inverse, err := perspectiveInverse(src, dst)
+	if err != nil {
+		return err
+	}
+	width, height := opts.size(pgm.width, pgm.height)
+	pgm.setRaster(warp(pgm.raster(), width, height, inverse, opts.interpolation(), []uint16{background}))
+	return nil
+}
+
+// Affine transforms the PPM image by m. The areas the image does not cover are
+// set to background.
+func (ppm *PPM) Affine(m Matrix, background Pixel, opts *WarpOptions) error {
+	inverse, err := affineInverse(m)
+	if err != nil {
+		return err
+	}
+	width, height := opts.size(ppm.width, ppm.height)
+	ppm.setRaster(warp(ppm.raster(), width, height, inverse, opts.interpolation(), []uint16{background.R, background.G, background.B}))
+	return nil
+}
+
+// Perspective applies to the PPM image the projective transform that takes the
+// corners of src to those of dst, such as the corners of a photographed page
+// to those of a rectangle. The areas the image does not cover are set to
+// background.
+func (ppm *PPM) Perspective(src, dst [4]Point, background Pixel, opts *WarpOptions) error {
+	inverse, err := perspectiveInverse(src, dst)
+	if err != nil {
+		return err
+	}
+	width, height := opts.size(ppm.width, ppm.height)
+	ppm.setRaster(warp(ppm.raster(), width, height, inverse, opts.interpolation(), []uint16{background.R, background.G, background.B}))
+	return nil
+}